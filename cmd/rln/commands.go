@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/waku-org/go-rln/rln"
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+type keyPairJSON struct {
+	IDKey        string `json:"idKey"`
+	IDCommitment string `json:"idCommitment"`
+}
+
+func toKeyPairJSON(keypair rln.MembershipKeyPair) keyPairJSON {
+	return keyPairJSON{
+		IDKey:        hex.EncodeToString(keypair.IDKey[:]),
+		IDCommitment: hex.EncodeToString(keypair.IDCommitment[:]),
+	}
+}
+
+func parseHex32(s string) ([32]byte, error) {
+	var result [32]byte
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return result, err
+	}
+	if len(b) != len(result) {
+		return result, fmt.Errorf("expected %d bytes, got %d", len(result), len(b))
+	}
+	copy(result[:], b)
+	return result, nil
+}
+
+// keyFlags are the flags selecting the identity key of a member
+type keyFlags struct {
+	key     string
+	keyFile string
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyFlags {
+	k := &keyFlags{}
+	fs.StringVar(&k.key, "key", "", "hex encoded identity key")
+	fs.StringVar(&k.keyFile, "key-file", "", "membership key pair file written by keygen")
+	return k
+}
+
+// keyPair returns the membership key pair of the identity key, the commitment is always recomputed
+func (k *keyFlags) keyPair() (rln.MembershipKeyPair, error) {
+	encoded := k.key
+	switch {
+	case k.key != "" && k.keyFile != "":
+		return rln.MembershipKeyPair{}, errors.New("--key and --key-file are exclusive")
+	case k.keyFile != "":
+		data, err := ioutil.ReadFile(k.keyFile)
+		if err != nil {
+			return rln.MembershipKeyPair{}, err
+		}
+		var stored keyPairJSON
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return rln.MembershipKeyPair{}, fmt.Errorf("could not parse the key file: %w", err)
+		}
+		encoded = stored.IDKey
+	case k.key == "":
+		return rln.MembershipKeyPair{}, errors.New("an identity key is required, use --key or --key-file")
+	}
+
+	idKey, err := parseHex32(encoded)
+	if err != nil {
+		return rln.MembershipKeyPair{}, fmt.Errorf("invalid identity key: %w", err)
+	}
+
+	idCommitment, err := poseidon.HashBytes(idKey)
+	if err != nil {
+		return rln.MembershipKeyPair{}, fmt.Errorf("invalid identity key: %w", err)
+	}
+
+	return rln.MembershipKeyPair{IDKey: idKey, IDCommitment: idCommitment}, nil
+}
+
+func runKeygen(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, opts := newFlagSet("keygen")
+	out := fs.String("out", "", "file the key pair is written to, as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r, err := opts.newRLN()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	keypair, err := r.MembershipKeyGen()
+	if err != nil {
+		return err
+	}
+
+	encoded := toKeyPairJSON(*keypair)
+	if *out != "" {
+		data, err := json.MarshalIndent(encoded, "", "  ")
+		if err != nil {
+			return err
+		}
+		// the file holds a secret key
+		if err := ioutil.WriteFile(*out, append(data, '\n'), 0600); err != nil {
+			return err
+		}
+		return opts.print(stdout, struct {
+			IDCommitment string `json:"idCommitment"`
+		}{encoded.IDCommitment}, encoded.IDCommitment)
+	}
+
+	return opts.print(stdout, encoded, fmt.Sprintf("idKey: %s\nidCommitment: %s", encoded.IDKey, encoded.IDCommitment))
+}
+
+func runCommit(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, opts := newFlagSet("commit")
+	key := addKeyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keypair, err := key.keyPair()
+	if err != nil {
+		return err
+	}
+
+	encoded := hex.EncodeToString(keypair.IDCommitment[:])
+	return opts.print(stdout, struct {
+		IDCommitment string `json:"idCommitment"`
+	}{encoded}, encoded)
+}
+
+func runTree(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "build" {
+		return errors.New("usage: rln tree build [--file file]")
+	}
+
+	fs, opts := newFlagSet("tree build")
+	file := fs.String("file", "", "file holding the identity commitments, as a JSON array or one per line, defaults to stdin")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	data, err := readInput(*file, stdin)
+	if err != nil {
+		return err
+	}
+
+	commitments, err := parseCommitments(data)
+	if err != nil {
+		return err
+	}
+
+	params, err := opts.loadParams()
+	if err != nil {
+		return err
+	}
+
+	root, err := rln.CalcMerkleRoot(commitments, params.Bytes())
+	if err != nil {
+		return err
+	}
+
+	encoded := hex.EncodeToString(root[:])
+	return opts.print(stdout, struct {
+		Root    string `json:"root"`
+		Members int    `json:"members"`
+	}{encoded, len(commitments)}, encoded)
+}
+
+// loadTree inserts the identity commitments of the file in the Merkle tree of `r`
+func loadTree(r *rln.RLN, path string, stdin io.Reader) error {
+	data, err := readInput(path, stdin)
+	if err != nil {
+		return err
+	}
+
+	commitments, err := parseCommitments(data)
+	if err != nil {
+		return err
+	}
+
+	return r.InsertAll(commitments)
+}
+
+func runProve(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, opts := newFlagSet("prove")
+	key := addKeyFlags(fs)
+	tree := fs.String("tree", "", "file holding the identity commitments of the group, as a JSON array or one per line")
+	index := fs.Uint("index", 0, "membership index of the key in the group")
+	epoch := fs.String("epoch", "", "epoch of the proof, defaults to the current epoch")
+	signal := fs.String("signal", "", "signal the proof is generated for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *tree == "" {
+		return errors.New("the group is required, use --tree")
+	}
+
+	keypair, err := key.keyPair()
+	if err != nil {
+		return err
+	}
+
+	e := rln.GetCurrentEpoch()
+	if *epoch != "" {
+		n, err := strconv.ParseUint(*epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid epoch: %w", err)
+		}
+		e = rln.ToEpoch(n)
+	}
+
+	r, err := opts.newRLN()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := loadTree(r, *tree, stdin); err != nil {
+		return err
+	}
+
+	if i, ok := r.IndexOf(keypair.IDCommitment); !ok || i != *index {
+		return fmt.Errorf("the identity commitment of the key is not at index %d of the group", *index)
+	}
+
+	proof, err := r.GenerateProof([]byte(*signal), keypair, *index, e)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return opts.print(stdout, proof, "")
+	}
+
+	text, err := proof.MarshalText()
+	if err != nil {
+		return err
+	}
+	return opts.print(stdout, nil, string(text))
+}
+
+// parseProof decodes a proof encoded as JSON or as hex
+func parseProof(data []byte) (rln.RateLimitProof, error) {
+	var proof rln.RateLimitProof
+
+	trimmed := strings.TrimSpace(string(data))
+	var err error
+	if strings.HasPrefix(trimmed, "{") {
+		err = json.Unmarshal([]byte(trimmed), &proof)
+	} else {
+		err = proof.UnmarshalText([]byte(trimmed))
+	}
+	if err != nil {
+		return rln.RateLimitProof{}, fmt.Errorf("could not parse the proof: %w", err)
+	}
+
+	return proof, nil
+}
+
+func runVerify(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, opts := newFlagSet("verify")
+	proofFile := fs.String("proof", "", "file holding the proof, as JSON or hex, defaults to stdin")
+	signal := fs.String("signal", "", "signal the proof was generated for")
+	tree := fs.String("tree", "", "file holding the identity commitments of the group, the proof must be generated against its root")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := readInput(*proofFile, stdin)
+	if err != nil {
+		return err
+	}
+
+	proof, err := parseProof(data)
+	if err != nil {
+		return err
+	}
+
+	r, err := opts.newRLN()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var verified bool
+	reason := ""
+	if *tree != "" {
+		if err := loadTree(r, *tree, stdin); err != nil {
+			return err
+		}
+		root, err := r.GetMerkleRoot()
+		if err != nil {
+			return err
+		}
+		verified, err = r.VerifyWithRoots([]byte(*signal), proof, []rln.MerkleNode{root})
+		if errors.Is(err, rln.ErrUnknownRoot) {
+			reason = "the proof was not generated against the root of the group"
+		} else if err != nil {
+			return err
+		}
+	} else {
+		verified, err = r.VerifyProof([]byte(*signal), proof)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !verified && reason == "" {
+		reason = "the proof does not verify"
+	}
+
+	text := "valid"
+	if !verified {
+		text = "invalid: " + reason
+	}
+
+	if err := opts.print(stdout, struct {
+		Valid  bool   `json:"valid"`
+		Reason string `json:"reason,omitempty"`
+	}{verified, reason}, text); err != nil {
+		return err
+	}
+
+	if !verified {
+		return errInvalid
+	}
+	return nil
+}
+
+func runHash(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, opts := newFlagSet("hash")
+	isHex := fs.Bool("hex", false, "the data is hex encoded")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var data []byte
+	if fs.NArg() > 0 {
+		data = []byte(strings.Join(fs.Args(), " "))
+	} else {
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		data = input
+	}
+
+	if *isHex {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid hex data: %w", err)
+		}
+		data = decoded
+	}
+
+	r, err := opts.newRLN()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hash, err := r.Hash(data)
+	if err != nil {
+		return err
+	}
+
+	encoded := hex.EncodeToString(hash[:])
+	return opts.print(stdout, struct {
+		Hash string `json:"hash"`
+	}{encoded}, encoded)
+}
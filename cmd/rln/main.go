@@ -0,0 +1,168 @@
+// Command rln generates membership keys, builds membership Merkle trees, and generates and verifies
+// proofs with the rln lib. Run `rln help` for the list of subcommands
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/waku-org/go-rln/rln"
+)
+
+// paramsEnv is the environment variable holding the path of the parameters file, used when --params is not set
+const paramsEnv = "RLN_PARAMS"
+
+// errInvalid is returned by the subcommands whose check failed, the process exits with status 1 without
+// printing an error
+var errInvalid = errors.New("invalid")
+
+type command struct {
+	usage string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"keygen": {"keygen [--out file]: generate a membership key pair", runKeygen},
+	"commit": {"commit --key hex: compute the identity commitment of an identity key", runCommit},
+	"tree":   {"tree build [--file file]: print the root of the Merkle tree built from a list of identity commitments", runTree},
+	"prove":  {"prove --key hex --tree file --index n [--epoch n] --signal text: generate a proof for a signal", runProve},
+	"verify": {"verify --proof proof --signal text [--tree file]: verify the proof of a signal", runVerify},
+	"hash":   {"hash [--hex] data: hash data to a field element", runHash},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+
+	err := cmd.run(args[1:], stdin, stdout)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errInvalid):
+		return 1
+	case errors.Is(err, flag.ErrHelp):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "rln %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: rln <command> [flags]")
+	fmt.Fprintln(w)
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Every command accepts --json to print JSON, and --params to set the parameters file (default $%s)\n", paramsEnv)
+}
+
+// options holds the flags shared by all the subcommands
+type options struct {
+	params string
+	json   bool
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	opts := &options{}
+	fs.StringVar(&opts.params, "params", "", "path of the zkSNARK parameters file, defaults to $"+paramsEnv)
+	fs.BoolVar(&opts.json, "json", false, "print the output as JSON")
+	return fs, opts
+}
+
+func (o *options) loadParams() (*rln.Params, error) {
+	path := o.params
+	if path == "" {
+		path = os.Getenv(paramsEnv)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("no parameters file, use --params or set $%s", paramsEnv)
+	}
+	return rln.LoadParams(path)
+}
+
+func (o *options) newRLN() (*rln.RLN, error) {
+	params, err := o.loadParams()
+	if err != nil {
+		return nil, err
+	}
+	return rln.NewRLNWithParams(params)
+}
+
+// print writes `v` as JSON when --json is set, and `text` otherwise
+func (o *options) print(w io.Writer, v interface{}, text string) error {
+	if !o.json {
+		_, err := fmt.Fprintln(w, text)
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// readInput returns the content of the file at `path`, or of stdin if the path is empty or "-"
+func readInput(path string, stdin io.Reader) ([]byte, error) {
+	if path == "" || path == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// parseCommitments parses a list of hex encoded identity commitments, either as a JSON array
+// or as one commitment per line
+func parseCommitments(data []byte) ([]rln.IDCommitment, error) {
+	var encoded []string
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &encoded); err != nil {
+			return nil, fmt.Errorf("could not parse the commitments: %w", err)
+		}
+	} else {
+		for _, line := range strings.Split(trimmed, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				encoded = append(encoded, line)
+			}
+		}
+	}
+
+	commitments := make([]rln.IDCommitment, len(encoded))
+	for i, e := range encoded {
+		c, err := parseHex32(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commitment %d: %w", i, err)
+		}
+		commitments[i] = c
+	}
+
+	return commitments, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/waku-org/go-rln/rln"
+)
+
+const testParams = "../../rln/testdata/parameters.key"
+
+func runCommand(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestUsage(t *testing.T) {
+	status, _, stderr := runCommand(t, "")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, "usage: rln <command>")
+
+	status, _, stderr = runCommand(t, "", "unknown")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, `unknown command "unknown"`)
+}
+
+func TestMissingParams(t *testing.T) {
+	t.Setenv(paramsEnv, "")
+
+	status, _, stderr := runCommand(t, "", "hash", "Hello")
+	require.Equal(t, 1, status)
+	require.Contains(t, stderr, "no parameters file")
+}
+
+func TestCommit(t *testing.T) {
+	key := rln.STATIC_GROUP_KEYS[0]
+
+	status, stdout, _ := runCommand(t, "", "commit", "--key", key[0])
+	require.Equal(t, 0, status)
+	require.Equal(t, key[1]+"\n", stdout)
+
+	status, stdout, _ = runCommand(t, "", "commit", "--json", "--key", "0x"+key[0])
+	require.Equal(t, 0, status)
+	require.JSONEq(t, `{"idCommitment": "`+key[1]+`"}`, stdout)
+
+	keyFile := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(`{"idKey": "`+key[0]+`"}`), 0600))
+	status, stdout, _ = runCommand(t, "", "commit", "--key-file", keyFile)
+	require.Equal(t, 0, status)
+	require.Equal(t, key[1]+"\n", stdout)
+
+	status, _, stderr := runCommand(t, "", "commit", "--key", "abcd")
+	require.Equal(t, 1, status)
+	require.Contains(t, stderr, "invalid identity key")
+}
+
+func TestParseCommitments(t *testing.T) {
+	var lines []string
+	for _, key := range rln.STATIC_GROUP_KEYS[:3] {
+		lines = append(lines, key[1])
+	}
+
+	fromLines, err := parseCommitments([]byte(strings.Join(lines, "\n") + "\n\n"))
+	require.NoError(t, err)
+	require.Len(t, fromLines, 3)
+
+	encoded, err := json.Marshal(lines)
+	require.NoError(t, err)
+	fromJSON, err := parseCommitments(encoded)
+	require.NoError(t, err)
+	require.Equal(t, fromLines, fromJSON)
+
+	_, err = parseCommitments([]byte(lines[0] + "\nzz"))
+	require.EqualError(t, err, "invalid commitment 1: encoding/hex: invalid byte: U+007A 'z'")
+}
+
+func TestParseProof(t *testing.T) {
+	var proof rln.RateLimitProof
+	for i := range proof.Proof {
+		proof.Proof[i] = byte(i)
+	}
+	proof.Epoch = rln.ToEpoch(42)
+
+	text, err := proof.MarshalText()
+	require.NoError(t, err)
+	decoded, err := parseProof(append(text, '\n'))
+	require.NoError(t, err)
+	require.Equal(t, proof, decoded)
+
+	encoded, err := json.Marshal(proof)
+	require.NoError(t, err)
+	decoded, err = parseProof(encoded)
+	require.NoError(t, err)
+	require.Equal(t, proof, decoded)
+
+	_, err = parseProof([]byte("{}"))
+	require.Error(t, err)
+}
+
+func TestProveAndVerify(t *testing.T) {
+	t.Setenv(paramsEnv, testParams)
+	dir := t.TempDir()
+
+	keyFile := filepath.Join(dir, "key.json")
+	status, stdout, stderr := runCommand(t, "", "keygen", "--out", keyFile)
+	require.Equal(t, 0, status, stderr)
+	commitment := strings.TrimSpace(stdout)
+
+	var commitments []string
+	for _, key := range rln.STATIC_GROUP_KEYS[:4] {
+		commitments = append(commitments, key[1])
+	}
+	commitments = append(commitments, commitment)
+	treeFile := filepath.Join(dir, "tree.txt")
+	require.NoError(t, ioutil.WriteFile(treeFile, []byte(strings.Join(commitments, "\n")), 0600))
+
+	status, stdout, stderr = runCommand(t, "", "tree", "build", "--json", "--file", treeFile)
+	require.Equal(t, 0, status, stderr)
+	var tree struct {
+		Root    string `json:"root"`
+		Members int    `json:"members"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &tree))
+	require.Equal(t, 5, tree.Members)
+
+	status, stdout, stderr = runCommand(t, "", "prove", "--key-file", keyFile, "--tree", treeFile, "--index", "4", "--epoch", "1000", "--signal", "Hello", "--json")
+	require.Equal(t, 0, status, stderr)
+	var proof rln.RateLimitProof
+	require.NoError(t, json.Unmarshal([]byte(stdout), &proof))
+	require.Equal(t, rln.ToEpoch(1000), proof.Epoch)
+
+	proofFile := filepath.Join(dir, "proof.json")
+	require.NoError(t, ioutil.WriteFile(proofFile, []byte(stdout), 0600))
+
+	status, stdout, stderr = runCommand(t, "", "verify", "--proof", proofFile, "--signal", "Hello", "--tree", treeFile)
+	require.Equal(t, 0, status, stderr)
+	require.Equal(t, "valid\n", stdout)
+
+	status, stdout, _ = runCommand(t, "", "verify", "--proof", proofFile, "--signal", "Bye", "--json")
+	require.Equal(t, 1, status)
+	require.JSONEq(t, `{"valid": false, "reason": "the proof does not verify"}`, stdout)
+
+	// the key is not at the given index
+	status, _, stderr = runCommand(t, "", "prove", "--key-file", keyFile, "--tree", treeFile, "--index", "3", "--signal", "Hello")
+	require.Equal(t, 1, status)
+	require.Contains(t, stderr, "not at index 3")
+}
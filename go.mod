@@ -2,12 +2,19 @@ module github.com/waku-org/go-rln
 
 go 1.17
 
-require github.com/stretchr/testify v1.7.2
+require (
+	github.com/consensys/gnark-crypto v0.7.0
+	github.com/stretchr/testify v1.7.2
+	github.com/tyler-smith/go-bip39 v1.1.0
+	golang.org/x/crypto v0.1.0
+	google.golang.org/protobuf v1.32.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.1.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
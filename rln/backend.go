@@ -0,0 +1,29 @@
+package rln
+
+// Backend implements the primitives RLN is built on: key generation, hashing, the membership
+// Merkle tree, and proof generation and verification. The native backend binds the rln lib,
+// and FakeBackend is an in-memory implementation for tests.
+//
+// The tree of a backend can only be appended to, or have a leaf replaced by a zero leaf, which
+// is what the rln lib supports. RLN keeps a mirror of the tree to read its leaves and paths.
+// RLN serializes the calls that modify the tree, while the other methods can be called concurrently
+type Backend interface {
+	// Depth returns the depth of the Merkle tree
+	Depth() int
+	// KeyGen generates a membership key pair
+	KeyGen() (MembershipKeyPair, error)
+	// Hash maps arbitrary data to a field element
+	Hash(data []byte) (MerkleNode, error)
+	// AppendLeaf sets the leaf at the next index of the tree
+	AppendLeaf(idComm IDCommitment) error
+	// DeleteLeaf replaces the leaf at `index` with a zero leaf
+	DeleteLeaf(index MembershipIndex) error
+	// Root returns the root of the tree
+	Root() (MerkleNode, error)
+	// Prove generates a proof that the member at `index` of the tree, holding the identity key,
+	// published `data` in the epoch
+	Prove(data []byte, key IDKey, index MembershipIndex, epoch Epoch) (RateLimitProof, error)
+	// Verify checks a proof generated for `data`. It returns false and no error if the proof is
+	// invalid, and an error if the verification could not run
+	Verify(data []byte, proof RateLimitProof) (bool, error)
+}
@@ -0,0 +1,169 @@
+package rln
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/waku-org/go-rln/rln/merkle"
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+// FakeBackend is an in-memory Backend for tests, which does not need the rln lib nor parameters.
+// Key pairs, the Merkle tree, shares and nullifiers are computed as in the rln lib, so they can be
+// used to test the tree, nullifiers and the recovery of keys. The zkSNARK of a proof is replaced by
+// a hash of its public inputs: it proves nothing, anyone can forge it, and it holds no secret
+type FakeBackend struct {
+	mu   sync.RWMutex
+	tree *merkle.Tree
+}
+
+// NewFakeBackend creates a FakeBackend with an empty tree of the given depth
+func NewFakeBackend(depth int) (*FakeBackend, error) {
+	tree, err := merkle.New(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FakeBackend{tree: tree}, nil
+}
+
+func (b *FakeBackend) Depth() int {
+	return b.tree.Depth()
+}
+
+// KeyGen generates a random identity key, and its commitment is the Poseidon hash of the key
+func (b *FakeBackend) KeyGen() (MembershipKeyPair, error) {
+	var key fr.Element
+	if _, err := key.SetRandom(); err != nil {
+		return MembershipKeyPair{}, err
+	}
+
+	return MembershipKeyPair{
+		IDKey:        poseidon.ToBytes(key),
+		IDCommitment: poseidon.ToBytes(poseidon.Hash(key)),
+	}, nil
+}
+
+// Hash maps the SHA-256 hash of the data to a field element. It does not give the same field
+// element as the rln lib
+func (b *FakeBackend) Hash(data []byte) (MerkleNode, error) {
+	return poseidon.ToBytes(fakeHash(data)), nil
+}
+
+func (b *FakeBackend) AppendLeaf(idComm IDCommitment) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, err := b.tree.Insert(idComm)
+	return err
+}
+
+func (b *FakeBackend) DeleteLeaf(index MembershipIndex) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tree.Delete(index)
+}
+
+func (b *FakeBackend) Root() (MerkleNode, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.tree.Root(), nil
+}
+
+// Prove computes the shares and nullifier of the member, see RecoverIDKey. If the identity
+// commitment of the key is not the leaf at `index`, a proof that does not verify is returned,
+// as the rln lib does
+func (b *FakeBackend) Prove(data []byte, key IDKey, index MembershipIndex, epoch Epoch) (RateLimitProof, error) {
+	a0, err := poseidon.FromBytes(key)
+	if err != nil {
+		return RateLimitProof{}, fmt.Errorf("invalid identity key: %w", err)
+	}
+
+	b.mu.RLock()
+	leaf, err := b.tree.Leaf(index)
+	root := b.tree.Root()
+	b.mu.RUnlock()
+	if err != nil {
+		return RateLimitProof{}, err
+	}
+
+	// y = a_0 + a_1 * x, where a_1 is fixed for the key and the epoch
+	x := fakeHash(data)
+	a1 := poseidon.Hash(a0, fakeEpoch(epoch))
+	var y fr.Element
+	y.Mul(&a1, &x)
+	y.Add(&y, &a0)
+
+	proof := RateLimitProof{
+		MerkleRoot: root,
+		Epoch:      epoch,
+		ShareX:     poseidon.ToBytes(x),
+		ShareY:     poseidon.ToBytes(y),
+		Nullifier:  poseidon.ToBytes(poseidon.Hash(a1)),
+	}
+	proof.Proof = fakeSNARK(proof)
+
+	if leaf != poseidon.ToBytes(poseidon.Hash(a0)) {
+		proof.Proof[0] ^= 0xff
+	}
+
+	return proof, nil
+}
+
+// Verify checks that the proof was generated by a FakeBackend for the data, with the same public inputs
+func (b *FakeBackend) Verify(data []byte, proof RateLimitProof) (bool, error) {
+	x := fakeHash(data)
+	if proof.ShareX != poseidon.ToBytes(x) {
+		return false, nil
+	}
+
+	return proof.Proof == fakeSNARK(proof), nil
+}
+
+// fakeHash maps the SHA-256 hash of the data to a field element
+func fakeHash(data []byte) fr.Element {
+	h := sha256.Sum256(data)
+
+	var e fr.Element
+	e.SetBytes(h[:])
+	return e
+}
+
+// fakeEpoch maps an epoch to a field element, reducing the values that are not canonical
+func fakeEpoch(epoch Epoch) fr.Element {
+	be := make([]byte, len(epoch))
+	for i, v := range epoch {
+		be[len(be)-1-i] = v
+	}
+
+	var e fr.Element
+	e.SetBytes(be)
+	return e
+}
+
+// fakeSNARK fills the zkSNARK of a fake proof with a SHA-256 based expansion of its public inputs
+func fakeSNARK(proof RateLimitProof) ZKSNARK {
+	seed := sha256.New()
+	seed.Write([]byte("go-rln fake backend"))
+	seed.Write(proof.MerkleRoot[:])
+	seed.Write(proof.Epoch[:])
+	seed.Write(proof.ShareX[:])
+	seed.Write(proof.ShareY[:])
+	seed.Write(proof.Nullifier[:])
+	digest := seed.Sum(nil)
+
+	var result ZKSNARK
+	for i := 0; i < len(result); i += sha256.Size {
+		block := make([]byte, 8, 8+len(digest))
+		binary.LittleEndian.PutUint64(block, uint64(i/sha256.Size))
+		sum := sha256.Sum256(append(block, digest...))
+		copy(result[i:], sum[:])
+	}
+
+	return result
+}
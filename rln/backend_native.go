@@ -0,0 +1,172 @@
+package rln
+
+/*
+#include "./librln.h"
+*/
+import "C"
+import (
+	"errors"
+	"unsafe"
+)
+
+// nativeBackend is the Backend binding the rln lib
+type nativeBackend struct {
+	ptr   *C.RLN_Bn256
+	depth int
+}
+
+func newNativeBackend(depth int, params *Params) (*nativeBackend, error) {
+	in := toCBuffer(params.Bytes())
+	defer freeCBuffer(&in)
+
+	var ptr *C.RLN_Bn256
+	if !bool(C.new_circuit_from_params(C.uintptr_t(depth), &in, &ptr)) {
+		return nil, errors.New("failed to initialize")
+	}
+
+	return &nativeBackend{ptr: ptr, depth: depth}, nil
+}
+
+func (b *nativeBackend) Depth() int {
+	return b.depth
+}
+
+func (b *nativeBackend) KeyGen() (MembershipKeyPair, error) {
+	var buffer C.Buffer
+	if !bool(C.key_gen(b.ptr, &buffer)) {
+		return MembershipKeyPair{}, errors.New("error in key generation")
+	}
+
+	// the public and secret keys together are 64 bytes
+	generatedKeys := fromOutputBuffer(&buffer)
+	if len(generatedKeys) != 64 {
+		return MembershipKeyPair{}, errors.New("the generated keys are invalid")
+	}
+
+	var key MembershipKeyPair
+	copy(key.IDKey[:], generatedKeys[:32])
+	copy(key.IDCommitment[:], generatedKeys[32:64])
+
+	return key, nil
+}
+
+func (b *nativeBackend) Hash(data []byte) (MerkleNode, error) {
+	//  a thin layer on top of the Nim wrapper of the Poseidon hasher
+	lenPrefData := appendLength(data)
+
+	in := toCBuffer(lenPrefData)
+	defer freeCBuffer(&in)
+
+	var out C.Buffer
+	if !bool(C.signal_to_field(b.ptr, &in, &out)) {
+		return MerkleNode{}, errors.New("failed to hash")
+	}
+
+	var result MerkleNode
+	copy(result[:], fromOutputBuffer(&out))
+
+	return result, nil
+}
+
+func (b *nativeBackend) AppendLeaf(idComm IDCommitment) error {
+	in := toCBuffer(idComm[:])
+	defer freeCBuffer(&in)
+
+	if !bool(C.update_next_member(b.ptr, &in)) {
+		return errors.New("could not insert member")
+	}
+	return nil
+}
+
+func (b *nativeBackend) DeleteLeaf(index MembershipIndex) error {
+	if !bool(C.delete_member(b.ptr, C.uintptr_t(index))) {
+		return errors.New("could not delete member")
+	}
+	return nil
+}
+
+func (b *nativeBackend) Root() (MerkleNode, error) {
+	var out C.Buffer
+	if !bool(C.get_root(b.ptr, &out)) {
+		return MerkleNode{}, errors.New("could not get the root")
+	}
+
+	root := fromOutputBuffer(&out)
+	if len(root) != 32 {
+		return MerkleNode{}, errors.New("wrong output size")
+	}
+
+	var result MerkleNode
+	copy(result[:], root)
+
+	return result, nil
+}
+
+// Prove generates a proof, the output of the rln lib is parsed as
+// |proof<256>|root<32>|epoch<32>|share_x<32>|share_y<32>|nullifier<32>|
+func (b *nativeBackend) Prove(data []byte, key IDKey, index MembershipIndex, epoch Epoch) (RateLimitProof, error) {
+	input := serialize(key, index, epoch, data)
+	in := toCBuffer(input)
+	defer freeCBuffer(&in)
+
+	var out C.Buffer
+	if !bool(C.generate_proof(b.ptr, &in, &out)) {
+		return RateLimitProof{}, errors.New("could not generate the proof")
+	}
+
+	var proof RateLimitProof
+	if err := proof.UnmarshalBinary(fromOutputBuffer(&out)); err != nil {
+		return RateLimitProof{}, errors.New("invalid proof generated")
+	}
+
+	return proof, nil
+}
+
+// Verify verifies a proof, the input of the rln lib is
+// [ proof<256>| root<32>| epoch<32>| share_x<32>| share_y<32>| nullifier<32> | signal_len<8> | signal<var> ]
+func (b *nativeBackend) Verify(data []byte, proof RateLimitProof) (bool, error) {
+	in := toCBuffer(proof.serialize(data))
+	defer freeCBuffer(&in)
+
+	res := C.uint(0)
+	if !bool(C.verify(b.ptr, &in, &res)) {
+		return false, errors.New("could not verify the proof")
+	}
+
+	return uint32(res) == 0, nil
+}
+
+// toCBuffer copies the input to C memory and returns a buffer object that is used to communicate data
+// with the rln lib. Go memory can not be handed over to the rln lib inside a buffer, since cgo does not
+// allow C to hold Go pointers. The returned buffer must be released with freeCBuffer
+func toCBuffer(data []byte) C.Buffer {
+	if len(data) == 0 {
+		return C.Buffer{}
+	}
+
+	return C.Buffer{
+		ptr: (*C.uchar)(C.CBytes(data)),
+		len: C.uintptr_t(len(data)),
+	}
+}
+
+// freeCBuffer releases a buffer created with toCBuffer
+func freeCBuffer(buf *C.Buffer) {
+	if buf.ptr != nil {
+		C.free(unsafe.Pointer(buf.ptr))
+		buf.ptr = nil
+		buf.len = 0
+	}
+}
+
+// fromOutputBuffer copies the content of a buffer populated by the rln lib to Go memory and releases it
+func fromOutputBuffer(buf *C.Buffer) []byte {
+	if buf.ptr == nil {
+		return nil
+	}
+
+	b := C.GoBytes(unsafe.Pointer(buf.ptr), C.int(buf.len))
+	freeOutputBuffer(buf)
+
+	return b
+}
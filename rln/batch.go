@@ -0,0 +1,84 @@
+package rln
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// VerifyItem is a proof together with the data it was generated for
+type VerifyItem struct {
+	Data  []byte
+	Proof RateLimitProof
+}
+
+// VerifyBatch verifies a list of proofs, and returns whether each of them is valid. It is not a
+// batched verification: the rln lib verifies each proof on its own, VerifyBatch only runs these
+// single verifications on parallel workers, so it costs as much CPU as calling VerifyProof for
+// each item.
+//
+// A proof whose public inputs are not field elements is not verified and reported as invalid,
+// and the results of all the items are returned with an error of kind ErrInvalidProof, holding a
+// PositionError with the position of the first of them. If the verification of an item could not
+// run, no result is returned and the error, of kind ErrFFI, holds a PositionError with its position
+func (r *RLN) VerifyBatch(items []VerifyItem) ([]bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.backend == nil {
+		return nil, newError("VerifyBatch", ErrClosed, nil)
+	}
+
+	results := make([]bool, len(items))
+	if len(items) == 0 {
+		return results, nil
+	}
+
+	malformed := make([]bool, len(items))
+	firstMalformed := -1
+	for i, item := range items {
+		malformed[i] = checkProof("VerifyBatch", item.Proof) != nil
+		if malformed[i] && firstMalformed < 0 {
+			firstMalformed = i
+		}
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	errs := make([]error, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if malformed[i] {
+					continue
+				}
+				results[i], errs[i] = r.backend.Verify(items[i].Data, items[i].Proof)
+			}
+		}()
+	}
+
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, newError("VerifyBatch", ErrFFI, fmt.Errorf("%w: %v", &PositionError{Position: i}, err))
+		}
+	}
+
+	if firstMalformed >= 0 {
+		return results, newError("VerifyBatch", ErrInvalidProof, &PositionError{Position: firstMalformed})
+	}
+
+	return results, nil
+}
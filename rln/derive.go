@@ -0,0 +1,153 @@
+package rln
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/tyler-smith/go-bip39"
+	"github.com/waku-org/go-rln/rln/poseidon"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DEFAULT_DERIVATION_PATH is the derivation path of the first membership key of a seed
+const DEFAULT_DERIVATION_PATH = "m/0'"
+
+// Bounds of the length of a seed, the same as BIP-32
+const (
+	MIN_SEED_SIZE = 16
+	MAX_SEED_SIZE = 64
+)
+
+// derivationKey is the HMAC key of the master node, it separates the keys derived by this package
+// from the keys other schemes derive from the same seed
+var derivationKey = []byte("RLN seed")
+
+// hardenedOffset marks the indexes written with a ' or h suffix in a derivation path
+const hardenedOffset = uint32(1) << 31
+
+// DeriveMembershipKey derives a membership key pair from a seed, so that the key can be recovered
+// from the seed. The derivation follows the hardened derivation of BIP-32 with HMAC-SHA512: the
+// master node is derived from the seed, and each index of the path, such as "m/0'/1'", derives a
+// child node. The keys are not secp256k1 keys, so there are no public parent keys to derive from
+// and only hardened indexes are accepted. The identity key is the 64 byte key of the last node
+// reduced modulo the field order, and the identity commitment is its Poseidon hash, the relation
+// used by the rln lib
+func DeriveMembershipKey(seed []byte, path string) (MembershipKeyPair, error) {
+	if len(seed) < MIN_SEED_SIZE || len(seed) > MAX_SEED_SIZE {
+		return MembershipKeyPair{}, newError("DeriveMembershipKey", ErrInvalidSeed, fmt.Errorf("the seed must be %d to %d bytes long, got %d", MIN_SEED_SIZE, MAX_SEED_SIZE, len(seed)))
+	}
+
+	indexes, err := parseDerivationPath(path)
+	if err != nil {
+		return MembershipKeyPair{}, newError("DeriveMembershipKey", ErrInvalidSeed, err)
+	}
+
+	node := hmacSHA512(derivationKey, seed)
+	for _, index := range indexes {
+		// the child of (key, chain code) is HMAC-SHA512(chain code, 0x00 | key | index)
+		data := make([]byte, 1+32+4)
+		copy(data[1:], node[:32])
+		binary.BigEndian.PutUint32(data[33:], index)
+		node = hmacSHA512(node[32:], data)
+	}
+
+	// reducing 64 bytes makes the bias of the key negligible. A zero key, which has no valid
+	// commitment, is not reachable in practice but is rejected anyway
+	var key fr.Element
+	key.SetBigInt(new(big.Int).SetBytes(node))
+	if key.IsZero() {
+		return MembershipKeyPair{}, newError("DeriveMembershipKey", ErrInvalidSeed, errors.New("the path derives an invalid key, use another index"))
+	}
+
+	return MembershipKeyPair{
+		IDKey:        poseidon.ToBytes(key),
+		IDCommitment: poseidon.ToBytes(poseidon.Hash(key)),
+	}, nil
+}
+
+// DeriveMembershipKeyFromMnemonic derives a membership key pair from a BIP-39 mnemonic and an
+// optional passphrase, see DeriveMembershipKey. The seed is derived from the mnemonic as defined by
+// BIP-39. The words and the checksum of the mnemonic are checked against the English wordlist, so
+// that a mistyped mnemonic is rejected instead of deriving another key. Only ASCII passphrases are
+// accepted, since their Unicode normalization is not implemented
+func DeriveMembershipKeyFromMnemonic(mnemonic string, passphrase string, path string) (MembershipKeyPair, error) {
+	seed, err := mnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return MembershipKeyPair{}, newError("DeriveMembershipKeyFromMnemonic", ErrInvalidSeed, err)
+	}
+
+	return DeriveMembershipKey(seed, path)
+}
+
+// mnemonicSeed computes the BIP-39 seed of a mnemonic
+func mnemonicSeed(mnemonic string, passphrase string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("a mnemonic has 12, 15, 18, 21 or 24 words, got %d", len(words))
+	}
+
+	normalized := strings.Join(words, " ")
+	if !isASCII(normalized) || !isASCII(passphrase) {
+		return nil, errors.New("the mnemonic and the passphrase must be ASCII")
+	}
+
+	for i, word := range words {
+		if _, ok := bip39.GetWordIndex(word); !ok {
+			return nil, fmt.Errorf("word %d of the mnemonic is not in the BIP-39 English wordlist", i+1)
+		}
+	}
+	if _, err := bip39.EntropyFromMnemonic(normalized); err != nil {
+		return nil, errors.New("the checksum of the mnemonic is invalid")
+	}
+
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDerivationPath parses a path such as "m/0'/1'", where a ' or h suffix marks a hardened index.
+// Only hardened indexes are accepted
+func parseDerivationPath(path string) ([]uint32, error) {
+	segments := strings.Split(path, "/")
+	if segments[0] != "m" {
+		return nil, fmt.Errorf("the derivation path %q does not start with m", path)
+	}
+
+	indexes := make([]uint32, 0, len(segments)-1)
+	for _, segment := range segments[1:] {
+		trimmed := strings.TrimRight(segment, "'h")
+		if len(segment)-len(trimmed) != 1 {
+			return nil, fmt.Errorf("index %q in the derivation path %q is not hardened", segment, path)
+		}
+
+		index, err := strconv.ParseUint(trimmed, 10, 32)
+		if err != nil || uint32(index) >= hardenedOffset {
+			return nil, fmt.Errorf("invalid index %q in the derivation path %q", segment, path)
+		}
+
+		indexes = append(indexes, uint32(index)+hardenedOffset)
+	}
+
+	return indexes, nil
+}
+
+func hmacSHA512(key []byte, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
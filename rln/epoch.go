@@ -0,0 +1,133 @@
+package rln
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Clock provides the current time, it can be replaced to control time in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock that reads the time of the system
+var SystemClock Clock = systemClock{}
+
+// maxUnixSeconds is the latest unix time a time.Time holds, which counts its seconds from year 1
+const maxUnixSeconds = math.MaxInt64 - 62135596800
+
+// EpochConfig describes how time is divided in epochs. Epoch 0 starts at Genesis, and every
+// epoch lasts Unit. A zero Unit defaults to EPOCH_UNIT_SECONDS, a zero Genesis to the unix epoch
+// and a nil Clock to SystemClock
+type EpochConfig struct {
+	Unit    time.Duration
+	Genesis time.Time
+	Clock   Clock
+}
+
+// DefaultEpochConfig returns the epochs of the waku rln relay, used by the package level functions
+func DefaultEpochConfig() EpochConfig {
+	return EpochConfig{
+		Unit:    time.Duration(EPOCH_UNIT_SECONDS) * time.Second,
+		Genesis: time.Unix(0, 0),
+		Clock:   SystemClock,
+	}
+}
+
+// Current returns the epoch of the current time of the clock
+func (c EpochConfig) Current() Epoch {
+	return c.At(c.now())
+}
+
+// At returns the epoch a time belongs to. Times before Genesis belong to epoch 0, and times
+// whose epoch does not fit in 64 bits to the last epoch
+func (c EpochConfig) At(t time.Time) Epoch {
+	genesis := c.genesis()
+	if !t.After(genesis) {
+		return ToEpoch(0)
+	}
+
+	// the elapsed time is computed from the unix seconds, like Start, since t.Sub saturates after
+	// 292 years. The difference of two int64 is exact in an uint64 when it is positive
+	seconds := uint64(t.Unix()) - uint64(genesis.Unix())
+	nanoseconds := t.Nanosecond() - genesis.Nanosecond()
+	if nanoseconds < 0 {
+		seconds--
+		nanoseconds += int(time.Second)
+	}
+
+	// the elapsed nanoseconds take up to 94 bits, the quotient must fit in 64 bits
+	hi, lo := bits.Mul64(seconds, uint64(time.Second))
+	lo, carry := bits.Add64(lo, uint64(nanoseconds), 0)
+	hi += carry
+	if hi >= uint64(c.unit()) {
+		return ToEpoch(math.MaxUint64)
+	}
+
+	epoch, _ := bits.Div64(hi, lo, uint64(c.unit()))
+	return ToEpoch(epoch)
+}
+
+// Start returns the time at which an epoch starts. Epochs that start after the latest time a
+// time.Time holds start at that time
+func (c EpochConfig) Start(e Epoch) time.Time {
+	genesis := c.genesis()
+	latest := time.Unix(maxUnixSeconds, 0).In(genesis.Location())
+
+	// the offset is split in seconds and nanoseconds, a time.Duration overflows after 292 years
+	hi, lo := bits.Mul64(e.Uint64(), uint64(c.unit()))
+	if hi >= uint64(time.Second) {
+		return latest
+	}
+	seconds, nanoseconds := bits.Div64(hi, lo, uint64(time.Second))
+
+	// the last second is kept for the nanoseconds, which can add up to a second
+	if seconds >= uint64(maxUnixSeconds)-uint64(genesis.Unix()) {
+		return latest
+	}
+
+	return time.Unix(int64(uint64(genesis.Unix())+seconds), int64(genesis.Nanosecond())+int64(nanoseconds)).In(genesis.Location())
+}
+
+// End returns the time at which an epoch ends, that is, the start of the following epoch
+func (c EpochConfig) End(e Epoch) time.Time {
+	next := e.Uint64()
+	if next < math.MaxUint64 {
+		next++
+	}
+	return c.Start(ToEpoch(next))
+}
+
+// Until returns the duration until the start of the epoch `next`. Like time.Until, the
+// duration is negative if the epoch already started
+func (c EpochConfig) Until(next Epoch) time.Duration {
+	return c.Start(next).Sub(c.now())
+}
+
+func (c EpochConfig) unit() time.Duration {
+	if c.Unit <= 0 {
+		return time.Duration(EPOCH_UNIT_SECONDS) * time.Second
+	}
+	return c.Unit
+}
+
+func (c EpochConfig) genesis() time.Time {
+	if c.Genesis.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return c.Genesis
+}
+
+func (c EpochConfig) now() time.Time {
+	if c.Clock == nil {
+		return SystemClock.Now()
+	}
+	return c.Clock.Now()
+}
@@ -0,0 +1,91 @@
+package rln
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/waku-org/go-rln/rln/keys"
+)
+
+// Sentinel errors describing the kind of a failure. Errors returned by this package
+// can be matched against them with errors.Is
+var (
+	// ErrClosed is returned when an RLN instance is used after Close was called
+	ErrClosed = errors.New("rln instance is closed")
+	// ErrInvalidParams is returned when the zkSNARK parameters or the tree depth are not usable
+	ErrInvalidParams = errors.New("invalid parameters")
+	// ErrTreeFull is returned when inserting into a Merkle tree that has no empty leaves left
+	ErrTreeFull = errors.New("merkle tree is full")
+	// ErrInvalidIndex is returned when a membership index is outside of the Merkle tree
+	ErrInvalidIndex = errors.New("invalid membership index")
+	// ErrInvalidKey is returned when an identity key is not a field element
+	ErrInvalidKey = keys.ErrInvalidKey
+	// ErrInvalidCommitment is returned when an identity commitment is not a field element
+	ErrInvalidCommitment = keys.ErrInvalidCommitment
+	// ErrInvalidField is returned when a 32 byte value is not lower than the field modulus
+	ErrInvalidField = errors.New("invalid field element")
+	// ErrInvalidProof is returned when a proof is malformed
+	ErrInvalidProof = errors.New("invalid proof")
+	// ErrUnknownRoot is returned when a proof was generated against a Merkle tree root that is not accepted
+	ErrUnknownRoot = errors.New("unknown merkle root")
+	// ErrInvalidSnapshot is returned when a snapshot of the Merkle tree is corrupted
+	ErrInvalidSnapshot = errors.New("invalid tree snapshot")
+	// ErrUnsupportedSnapshot is returned when a snapshot of the Merkle tree was written in another version of the format
+	ErrUnsupportedSnapshot = errors.New("unsupported tree snapshot version")
+	// ErrUnsupported is returned when the backend can not perform an operation, such as replacing
+	// a leaf of the tree of the rln lib
+	ErrUnsupported = errors.New("operation not supported by the backend")
+	// ErrDuplicateMember is returned when an identity commitment is already a member of a group
+	ErrDuplicateMember = errors.New("duplicate group member")
+	// ErrQueueFull is returned when a proof request is rejected because the queue of the Prover is full
+	ErrQueueFull = errors.New("proof queue is full")
+	// ErrInvalidShares is returned when an identity key can not be recovered from a pair of proofs
+	ErrInvalidShares = errors.New("invalid shares")
+	// ErrInvalidSeed is returned when a membership key can not be derived from a seed, a mnemonic or a derivation path
+	ErrInvalidSeed = errors.New("invalid seed")
+	// ErrFFI is returned when a call to the rln lib fails or returns unexpected data
+	ErrFFI = errors.New("rln lib call failed")
+)
+
+// Error is the error returned by the operations of this package. Kind is one of the
+// sentinel errors above and Err optionally holds the underlying cause
+type Error struct {
+	// Op is the operation that failed, i.e. "Insert"
+	Op string
+	// Kind is the class of the error
+	Kind error
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op + ": " + e.Kind.Error()
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the kind `target`
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// PositionError is the cause of a failed operation on a list of members or proofs, it holds the
+// position in the list of the first item that could not be processed
+type PositionError struct {
+	Position int
+}
+
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("could not process item %d of the list", e.Position)
+}
+
+func newError(op string, kind error, err error) error {
+	return &Error{Op: op, Kind: kind, Err: err}
+}
@@ -0,0 +1,137 @@
+package rln
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+// Field is an element of the scalar field of BN254, the field of the values handled by the rln lib:
+// identity keys and commitments, Merkle tree nodes, epochs, shares and nullifiers. The zero value
+// is the element 0
+type Field struct {
+	e fr.Element
+}
+
+// FieldModulus returns the modulus of the scalar field of BN254
+func FieldModulus() *big.Int {
+	return fr.Modulus()
+}
+
+// IsCanonical reports whether a 32 byte value, encoded in little endian as done by the rln lib,
+// is lower than the field modulus. Any other value is rejected by FromBytesLE
+func IsCanonical(b [32]byte) bool {
+	_, err := poseidon.FromBytes(b)
+	return err == nil
+}
+
+// FromBytesLE decodes a field element encoded in little endian. It returns an error of kind
+// ErrInvalidField if the value is not lower than the field modulus, instead of reducing it
+func FromBytesLE(b [32]byte) (Field, error) {
+	e, err := poseidon.FromBytes(b)
+	if err != nil {
+		return Field{}, newError("FromBytesLE", ErrInvalidField, err)
+	}
+	return Field{e: e}, nil
+}
+
+// FromBig converts an integer to a field element. It returns an error of kind ErrInvalidField
+// if the integer is negative or not lower than the field modulus
+func FromBig(v *big.Int) (Field, error) {
+	if v.Sign() < 0 || v.Cmp(fr.Modulus()) >= 0 {
+		return Field{}, newError("FromBig", ErrInvalidField, fmt.Errorf("%s is out of the field", v))
+	}
+
+	var f Field
+	f.e.SetBigInt(v)
+	return f, nil
+}
+
+// Bytes encodes the element in little endian
+func (f Field) Bytes() [32]byte {
+	return poseidon.ToBytes(f.e)
+}
+
+// Big returns the element as an integer lower than the field modulus
+func (f Field) Big() *big.Int {
+	return f.e.ToBigIntRegular(new(big.Int))
+}
+
+// String returns the element in decimal
+func (f Field) String() string {
+	return f.Big().String()
+}
+
+// IsZero reports whether the element is 0
+func (f Field) IsZero() bool {
+	return f.e.IsZero()
+}
+
+// Equal reports whether both elements are equal
+func (f Field) Equal(g Field) bool {
+	return f.e.Equal(&g.e)
+}
+
+// Add returns f + g
+func (f Field) Add(g Field) Field {
+	var r Field
+	r.e.Add(&f.e, &g.e)
+	return r
+}
+
+// Sub returns f - g
+func (f Field) Sub(g Field) Field {
+	var r Field
+	r.e.Sub(&f.e, &g.e)
+	return r
+}
+
+// Mul returns f * g
+func (f Field) Mul(g Field) Field {
+	var r Field
+	r.e.Mul(&f.e, &g.e)
+	return r
+}
+
+// Inverse returns the multiplicative inverse of f. It returns an error of kind ErrInvalidField
+// if f is 0, which has no inverse
+func (f Field) Inverse() (Field, error) {
+	if f.e.IsZero() {
+		return Field{}, newError("Inverse", ErrInvalidField, errors.New("0 has no inverse"))
+	}
+
+	var r Field
+	r.e.Inverse(&f.e)
+	return r, nil
+}
+
+// checkField returns an error of the given kind if the value is not a field element, `name`
+// describes the value in the error
+func checkField(op string, kind error, name string, b [32]byte) error {
+	if !IsCanonical(b) {
+		return newError(op, kind, fmt.Errorf("%s is not lower than the field modulus", name))
+	}
+	return nil
+}
+
+// checkProof checks that the public inputs of a proof are field elements
+func checkProof(op string, proof RateLimitProof) error {
+	for _, input := range []struct {
+		name  string
+		value [32]byte
+	}{
+		{"the merkle root", proof.MerkleRoot},
+		{"the epoch", [32]byte(proof.Epoch)},
+		{"share_x", proof.ShareX},
+		{"share_y", proof.ShareY},
+		{"the nullifier", proof.Nullifier},
+	} {
+		if err := checkField(op, ErrInvalidProof, input.name, input.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
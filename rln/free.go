@@ -0,0 +1,23 @@
+//go:build !windows && !rln_leak_buffers
+// +build !windows,!rln_leak_buffers
+
+package rln
+
+/*
+#include "./librln.h"
+*/
+import "C"
+import "unsafe"
+
+// freeOutputBuffer releases a buffer allocated by the rln lib. The rust side hands out the
+// memory of a leaked Vec<u8>, and the rust system allocator is backed by malloc on unix
+// platforms, so it can be released with free. The rln lib does not export a function to release
+// its buffers, and this can not be checked at run time: a lib built with another global
+// allocator, such as jemalloc, must be linked with the rln_leak_buffers build tag
+func freeOutputBuffer(buf *C.Buffer) {
+	if buf.ptr != nil && buf.len != 0 {
+		C.free(unsafe.Pointer(buf.ptr))
+	}
+	buf.ptr = nil
+	buf.len = 0
+}
@@ -0,0 +1,24 @@
+//go:build !rln_leak_buffers
+// +build !rln_leak_buffers
+
+package rln
+
+/*
+#include <windows.h>
+#include "./librln.h"
+*/
+import "C"
+import "unsafe"
+
+// freeOutputBuffer releases a buffer allocated by the rln lib. The rust side hands out the
+// memory of a leaked Vec<u8>, and the rust system allocator allocates the buffers of byte vectors
+// with HeapAlloc on the process heap on windows, so they are released with HeapFree. The free of
+// the C runtime uses another heap and can not release them. As on other platforms, a lib built
+// with another global allocator must be linked with the rln_leak_buffers build tag
+func freeOutputBuffer(buf *C.Buffer) {
+	if buf.ptr != nil && buf.len != 0 {
+		C.HeapFree(C.GetProcessHeap(), 0, C.LPVOID(unsafe.Pointer(buf.ptr)))
+	}
+	buf.ptr = nil
+	buf.len = 0
+}
@@ -0,0 +1,228 @@
+package rln
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GroupManager keeps the Merkle tree of an RLN instance in sync with a membership group
+type GroupManager interface {
+	// RLN returns the instance holding the Merkle tree of the group
+	RLN() *RLN
+	// IndexOf returns the index of the member with the given identity commitment
+	IndexOf(idComm IDCommitment) (MembershipIndex, bool)
+	// Root returns the current root of the Merkle tree of the group
+	Root() (MerkleNode, error)
+	// OwnIndex returns the index of the member owning the membership key pair
+	// the manager was created with, if it is part of the group
+	OwnIndex() (MembershipIndex, bool)
+}
+
+// groupMembers implements the bookkeeping shared by the group managers
+type groupMembers struct {
+	mu          sync.RWMutex
+	rln         *RLN
+	own         *MembershipKeyPair
+	members     map[IDCommitment]MembershipIndex
+	commitments map[MembershipIndex]IDCommitment
+}
+
+func newGroupMembers(rln *RLN, own *MembershipKeyPair) groupMembers {
+	return groupMembers{
+		rln:         rln,
+		own:         own,
+		members:     make(map[IDCommitment]MembershipIndex),
+		commitments: make(map[MembershipIndex]IDCommitment),
+	}
+}
+
+func (g *groupMembers) RLN() *RLN {
+	return g.rln
+}
+
+func (g *groupMembers) IndexOf(idComm IDCommitment) (MembershipIndex, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	index, ok := g.members[idComm]
+	return index, ok
+}
+
+func (g *groupMembers) Root() (MerkleNode, error) {
+	return g.rln.GetMerkleRoot()
+}
+
+func (g *groupMembers) OwnIndex() (MembershipIndex, bool) {
+	if g.own == nil {
+		return 0, false
+	}
+	return g.IndexOf(g.own.IDCommitment)
+}
+
+// insert appends a member to the tree. It returns an error of kind ErrDuplicateMember if the
+// commitment is already a member of the group. The caller must hold the write lock
+func (g *groupMembers) insert(op string, idComm IDCommitment) (MembershipIndex, error) {
+	if index, ok := g.members[idComm]; ok {
+		return 0, newError(op, ErrDuplicateMember, fmt.Errorf("the commitment is already the member %d", index))
+	}
+
+	index, err := g.rln.Insert(idComm)
+	if err != nil {
+		return 0, err
+	}
+
+	g.add(index, idComm)
+
+	return index, nil
+}
+
+// add records a member of the tree. The caller must hold the write lock
+func (g *groupMembers) add(index MembershipIndex, idComm IDCommitment) {
+	g.members[idComm] = index
+	g.commitments[index] = idComm
+}
+
+// remove deletes the member at `index`. The caller must hold the write lock
+func (g *groupMembers) remove(index MembershipIndex) error {
+	if err := g.rln.Delete(index); err != nil {
+		return err
+	}
+
+	if idComm, ok := g.commitments[index]; ok {
+		delete(g.members, idComm)
+		delete(g.commitments, index)
+	}
+
+	return nil
+}
+
+// StaticGroup is a GroupManager for a group whose members are known upfront
+type StaticGroup struct {
+	groupMembers
+}
+
+// NewStaticGroup inserts the commitments in the Merkle tree of `rln`, in order. `own` is the
+// membership key pair of the local member, it can be nil. A commitment listed twice is rejected
+// with an error of kind ErrDuplicateMember
+func NewStaticGroup(rln *RLN, commitments []IDCommitment, own *MembershipKeyPair) (*StaticGroup, error) {
+	g := &StaticGroup{groupMembers: newGroupMembers(rln, own)}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, idComm := range commitments {
+		if _, err := g.insert("NewStaticGroup", idComm); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// FileGroup is a GroupManager for a group whose members are stored in a file. Members are only
+// ever appended to the file, and Reload inserts the ones appended since the last load
+type FileGroup struct {
+	groupMembers
+	path   string
+	loaded []IDCommitment
+}
+
+// LoadGroupFile creates a FileGroup out of the identity commitments stored in a file. Files
+// with a .json extension hold an array of hex encoded commitments, and files with a .csv extension
+// hold a hex encoded commitment per line. A commitment listed twice is rejected with an error of
+// kind ErrDuplicateMember
+func LoadGroupFile(rln *RLN, path string, own *MembershipKeyPair) (*FileGroup, error) {
+	g := &FileGroup{groupMembers: newGroupMembers(rln, own), path: path}
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Path returns the path of the group file
+func (g *FileGroup) Path() string {
+	return g.path
+}
+
+// Reload reads the group file again and inserts the members appended to it. The members already
+// loaded must still be the first ones of the file, in the same order
+func (g *FileGroup) Reload() error {
+	commitments, err := readGroupFile(g.path)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(commitments) < len(g.loaded) {
+		return fmt.Errorf("the group file holds %d members, %d were already loaded", len(commitments), len(g.loaded))
+	}
+	for i, idComm := range g.loaded {
+		if commitments[i] != idComm {
+			return fmt.Errorf("the member %d of the group file changed", i)
+		}
+	}
+
+	for _, idComm := range commitments[len(g.loaded):] {
+		if _, err := g.insert("Reload", idComm); err != nil {
+			return err
+		}
+		g.loaded = append(g.loaded, idComm)
+	}
+
+	return nil
+}
+
+// readGroupFile decodes the identity commitments stored in a group file
+func readGroupFile(path string) ([]IDCommitment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var encoded []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&encoded); err != nil {
+			return nil, fmt.Errorf("could not parse group file: %w", err)
+		}
+	case ".csv":
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = 1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not parse group file: %w", err)
+			}
+			encoded = append(encoded, strings.TrimSpace(record[0]))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported group file format %s", filepath.Ext(path))
+	}
+
+	commitments := make([]IDCommitment, len(encoded))
+	for i, e := range encoded {
+		b, err := hex.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commitment %d: %w", i, err)
+		}
+		if len(b) != len(commitments[i]) {
+			return nil, fmt.Errorf("invalid commitment %d: expected %d bytes", i, len(commitments[i]))
+		}
+		copy(commitments[i][:], b)
+	}
+
+	return commitments, nil
+}
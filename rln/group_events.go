@@ -0,0 +1,255 @@
+package rln
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Event is a change of the membership of a group, i.e. MemberRegistered or MemberRemoved
+type Event interface {
+	isEvent()
+}
+
+// MemberRegistered is emitted when a member joins the group
+type MemberRegistered struct {
+	Index      MembershipIndex
+	Commitment IDCommitment
+}
+
+// MemberRemoved is emitted when a member leaves the group or is slashed
+type MemberRemoved struct {
+	Index MembershipIndex
+}
+
+func (MemberRegistered) isEvent() {}
+func (MemberRemoved) isEvent()    {}
+
+// EventSource delivers the events of a membership group in the order they happened,
+// i.e. the events emitted by a membership contract
+type EventSource interface {
+	// Subscribe returns a channel delivering the events of the group, starting with the event at
+	// position `from`, 0 being the first event. The channel is closed when the source has no more
+	// events to deliver or the context is done
+	Subscribe(ctx context.Context, from uint64) (<-chan Event, error)
+}
+
+// DynamicGroup is a GroupManager for a group whose membership changes over time. It counts the
+// events applied to it, so that a later Sync resumes after the last applied event
+type DynamicGroup struct {
+	groupMembers
+	applied uint64
+}
+
+// NewDynamicGroup creates an empty group. The members are added and removed by applying
+// events to it. `own` is the membership key pair of the local member, it can be nil
+func NewDynamicGroup(rln *RLN, own *MembershipKeyPair) *DynamicGroup {
+	return &DynamicGroup{groupMembers: newGroupMembers(rln, own)}
+}
+
+// LoadDynamicGroup restores a group saved with Save into `rln`, whose Merkle tree must be empty.
+// A Sync of the restored group resumes after the last event applied before the group was saved
+func LoadDynamicGroup(rln *RLN, state io.Reader, own *MembershipKeyPair) (*DynamicGroup, error) {
+	g := NewDynamicGroup(rln, own)
+
+	if err := binary.Read(state, binary.LittleEndian, &g.applied); err != nil {
+		return nil, newError("LoadDynamicGroup", ErrInvalidSnapshot, err)
+	}
+	if err := rln.LoadTree(state); err != nil {
+		return nil, err
+	}
+
+	for index := MembershipIndex(0); index < rln.NextIndex(); index++ {
+		idComm, err := rln.GetMember(index)
+		if err != nil {
+			return nil, err
+		}
+		if idComm == (IDCommitment{}) {
+			continue
+		}
+		if _, ok := g.members[idComm]; ok {
+			return nil, newError("LoadDynamicGroup", ErrDuplicateMember, fmt.Errorf("the commitment of the member %d is listed twice", index))
+		}
+		g.add(index, idComm)
+	}
+
+	return g, nil
+}
+
+// Save writes the state of the group, the number of applied events followed by a snapshot of
+// the Merkle tree written by SaveTree. The group is restored with LoadDynamicGroup
+func (g *DynamicGroup) Save(w io.Writer) error {
+	// the lock keeps the tree and the number of applied events consistent
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := binary.Write(w, binary.LittleEndian, g.applied); err != nil {
+		return newError("Save", ErrInvalidSnapshot, err)
+	}
+	return g.rln.SaveTree(w)
+}
+
+// Applied returns the number of events applied to the group, which is the position of the next
+// event of the source
+func (g *DynamicGroup) Applied() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.applied
+}
+
+// Apply updates the Merkle tree with the next event of the group. Members must be registered in
+// index order, and a commitment that is already a member is rejected with an error of kind
+// ErrDuplicateMember. An event that can not be applied is not counted as applied
+func (g *DynamicGroup) Apply(event Event) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch e := event.(type) {
+	case MemberRegistered:
+		next := g.rln.NextIndex()
+		if e.Index != next {
+			return fmt.Errorf("member registered at index %d, expected %d", e.Index, next)
+		}
+		if _, err := g.insert("Apply", e.Commitment); err != nil {
+			return err
+		}
+	case MemberRemoved:
+		if err := g.remove(e.Index); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown event %T", event)
+	}
+
+	g.applied++
+
+	return nil
+}
+
+// Sync applies the events of the source, starting after the last applied event, until the source
+// has no more events or the context is done. It returns the first error found while applying an
+// event
+func (g *DynamicGroup) Sync(ctx context.Context, source EventSource) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := source.Subscribe(ctx, g.Applied())
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		if err := g.Apply(event); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// MemoryEventSource is an in-memory EventSource, which lets groups be tested without a
+// membership contract. It is safe for concurrent use
+type MemoryEventSource struct {
+	mu     sync.Mutex
+	events []Event
+	next   MembershipIndex
+	closed bool
+	// notify is closed and replaced whenever the log changes
+	notify chan struct{}
+}
+
+// NewMemoryEventSource creates an empty event source
+func NewMemoryEventSource() *MemoryEventSource {
+	return &MemoryEventSource{notify: make(chan struct{})}
+}
+
+// Register emits a MemberRegistered event for the next index and returns it
+func (s *MemoryEventSource) Register(idComm IDCommitment) MembershipIndex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.next
+	s.next++
+	s.emit(MemberRegistered{Index: index, Commitment: idComm})
+
+	return index
+}
+
+// Remove emits a MemberRemoved event
+func (s *MemoryEventSource) Remove(index MembershipIndex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emit(MemberRemoved{Index: index})
+}
+
+// Close ends the subscriptions once they delivered every event
+func (s *MemoryEventSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.notify)
+	}
+}
+
+func (s *MemoryEventSource) emit(event Event) {
+	if s.closed {
+		return
+	}
+
+	s.events = append(s.events, event)
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Subscribe returns a channel delivering the events of the source, starting with the event at
+// position `from`
+func (s *MemoryEventSource) Subscribe(ctx context.Context, from uint64) (<-chan Event, error) {
+	ch := make(chan Event)
+
+	go func() {
+		defer close(ch)
+
+		delivered := from
+		for {
+			s.mu.Lock()
+			var pending []Event
+			if delivered < uint64(len(s.events)) {
+				pending = s.events[delivered:]
+			}
+			closed := s.closed
+			notify := s.notify
+			s.mu.Unlock()
+
+			for _, event := range pending {
+				select {
+				case ch <- event:
+					delivered++
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if len(pending) != 0 {
+				continue
+			}
+
+			if closed {
+				return
+			}
+
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,372 @@
+package rln
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+// Names of the files of a journal directory
+const (
+	JOURNAL_SNAPSHOT_FILE = "tree.snapshot"
+	JOURNAL_LOG_FILE      = "journal.wal"
+)
+
+// JournalOp is the kind of change recorded in a journal
+type JournalOp byte
+
+const (
+	// JournalInsert records a member appended at the next index
+	JournalInsert JournalOp = iota + 1
+	// JournalDelete records a member replaced by a zero leaf
+	JournalDelete
+	// JournalSet records a leaf set at an arbitrary index
+	JournalSet
+)
+
+// A record holds, in little endian, seq<8> | op<1> | index<8> | commitment<32> | crc32c<4>, where
+// the checksum covers the fields that precede it. The snapshot file holds, in little endian,
+// seq<8> followed by a snapshot written by SaveTree, where seq is the sequence number of the last
+// record applied to the tree of the snapshot. The records up to it are not replayed, as the rln
+// lib can not apply a record twice
+const journalRecordSize = 8 + 1 + 8 + 32 + 4
+
+var journalCRC = crc32.MakeTable(crc32.Castagnoli)
+
+type journalRecord struct {
+	seq    uint64
+	op     JournalOp
+	index  MembershipIndex
+	idComm IDCommitment
+}
+
+func (rec journalRecord) encode() []byte {
+	b := make([]byte, journalRecordSize)
+	binary.LittleEndian.PutUint64(b[0:], rec.seq)
+	b[8] = byte(rec.op)
+	binary.LittleEndian.PutUint64(b[9:], uint64(rec.index))
+	copy(b[17:], rec.idComm[:])
+	binary.LittleEndian.PutUint32(b[49:], crc32.Checksum(b[:49], journalCRC))
+	return b
+}
+
+func decodeJournalRecord(b []byte) (journalRecord, bool) {
+	if crc32.Checksum(b[:49], journalCRC) != binary.LittleEndian.Uint32(b[49:]) {
+		return journalRecord{}, false
+	}
+
+	rec := journalRecord{
+		seq:   binary.LittleEndian.Uint64(b[0:]),
+		op:    JournalOp(b[8]),
+		index: MembershipIndex(binary.LittleEndian.Uint64(b[9:])),
+	}
+	copy(rec.idComm[:], b[17:49])
+
+	return rec, rec.op >= JournalInsert && rec.op <= JournalSet
+}
+
+// Journal makes the changes of the Merkle tree of an RLN instance durable. Each change is appended
+// to a write-ahead log before it is applied to the tree, and Checkpoint replaces the log with a
+// snapshot of the tree. Once a journal is opened, the tree must only be modified through it
+type Journal struct {
+	mu   sync.Mutex
+	rln  *RLN
+	dir  string
+	file *os.File
+	// size is the length of the valid records of the log
+	size int64
+	seq  uint64
+	// applied is the sequence number of the last record applied to the tree, it is lower than seq
+	// when a record was written but could not be applied
+	applied uint64
+}
+
+// OpenJournal opens the journal stored in `dir`, creating it if needed, and restores the tree of
+// `rln`, which must be empty, by loading the last snapshot and replaying the records of the log
+// that follow it. Records that were not completely written, such as the last record written
+// before a crash, are discarded
+func OpenJournal(rln *RLN, dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	var seq uint64
+	snapshot, err := os.Open(filepath.Join(dir, JOURNAL_SNAPSHOT_FILE))
+	switch {
+	case err == nil:
+		err = binary.Read(snapshot, binary.LittleEndian, &seq)
+		if err != nil {
+			err = newError("OpenJournal", ErrInvalidSnapshot, err)
+		} else {
+			err = rln.LoadTree(snapshot)
+		}
+		snapshot.Close()
+		if err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, JOURNAL_LOG_FILE), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{rln: rln, dir: dir, file: file, seq: seq, applied: seq}
+	if err := j.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// replay applies the valid records of the log that follow the snapshot, and truncates the log
+// after the last one. The log holds the records up to the snapshot if the process stopped
+// between the snapshot and the truncation of the log in Checkpoint
+func (j *Journal) replay() error {
+	data, err := ioutil.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+
+	valid := 0
+	var last uint64
+	for offset := 0; offset+journalRecordSize <= len(data); offset += journalRecordSize {
+		rec, ok := decodeJournalRecord(data[offset : offset+journalRecordSize])
+		if !ok || (valid > 0 && rec.seq != last+1) {
+			break
+		}
+		last = rec.seq
+
+		if rec.seq > j.seq {
+			if rec.seq != j.seq+1 {
+				return fmt.Errorf("journal record %d does not follow the snapshot of record %d", rec.seq, j.seq)
+			}
+			if err := j.apply(rec); err != nil {
+				return fmt.Errorf("could not replay journal record %d: %w", rec.seq, err)
+			}
+			j.seq, j.applied = rec.seq, rec.seq
+		}
+
+		valid = offset + journalRecordSize
+	}
+
+	if valid != len(data) {
+		if err := j.file.Truncate(int64(valid)); err != nil {
+			return err
+		}
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	j.size = int64(valid)
+	_, err = j.file.Seek(j.size, 0)
+	return err
+}
+
+// apply performs the change of a record on the tree
+func (j *Journal) apply(rec journalRecord) error {
+	switch rec.op {
+	case JournalDelete:
+		return j.rln.Delete(rec.index)
+	default:
+		return j.rln.SetMember(rec.index, rec.idComm)
+	}
+}
+
+// append writes a record to the log and applies it to the tree. If the record is written but can
+// not be applied, the error is returned and the record is applied again when the log is replayed,
+// the journal must then be reopened before the next Checkpoint. The caller must hold the lock
+func (j *Journal) append(op JournalOp, index MembershipIndex, idComm IDCommitment) error {
+	if j.file == nil {
+		return newError("Journal", ErrClosed, nil)
+	}
+
+	rec := journalRecord{seq: j.seq + 1, op: op, index: index, idComm: idComm}
+	if err := j.write(rec.encode()); err != nil {
+		return err
+	}
+	j.seq = rec.seq
+
+	if err := j.apply(rec); err != nil {
+		return err
+	}
+	j.applied = rec.seq
+
+	return nil
+}
+
+// write appends a record to the log, removing what was written of it if it fails
+func (j *Journal) write(record []byte) error {
+	_, err := j.file.Write(record)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// the next records must not follow a torn record
+		if truncErr := j.file.Truncate(j.size); truncErr == nil {
+			_, _ = j.file.Seek(j.size, 0)
+		}
+		return err
+	}
+
+	j.size += int64(len(record))
+	return nil
+}
+
+// checkMember validates a change before it is recorded, so that the records of the log can be replayed
+func (j *Journal) checkMember(op string, index MembershipIndex, idComm IDCommitment) error {
+	if !j.rln.validIndex(index) {
+		return newError(op, ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
+	}
+	if _, err := poseidon.FromBytes(idComm); err != nil {
+		return newError(op, ErrInvalidCommitment, err)
+	}
+	return nil
+}
+
+// Insert records and appends a member to the next index of the tree
+func (j *Journal) Insert(idComm IDCommitment) (MembershipIndex, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	index := j.rln.NextIndex()
+	if !j.rln.validIndex(index) {
+		return 0, newError("Insert", ErrTreeFull, nil)
+	}
+	if err := j.checkMember("Insert", index, idComm); err != nil {
+		return 0, err
+	}
+
+	return index, j.append(JournalInsert, index, idComm)
+}
+
+// Delete records and replaces the member at `index` with a zero leaf
+func (j *Journal) Delete(index MembershipIndex) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.checkMember("Delete", index, IDCommitment{}); err != nil {
+		return err
+	}
+
+	return j.append(JournalDelete, index, IDCommitment{})
+}
+
+// Set records and sets the leaf at `index`, see SetMember
+func (j *Journal) Set(index MembershipIndex, idComm IDCommitment) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.checkMember("Set", index, idComm); err != nil {
+		return err
+	}
+
+	// a change the tree does not support would be recorded but never applied
+	j.rln.mu.RLock()
+	err := j.rln.checkGap("Set", index)
+	if err == nil {
+		err = j.rln.checkReplace("Set", index, idComm)
+	}
+	j.rln.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return j.append(JournalSet, index, idComm)
+}
+
+// Sequence returns the sequence number of the last record
+func (j *Journal) Sequence() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.seq
+}
+
+// Checkpoint writes a snapshot of the tree and empties the log. The snapshot holds the sequence
+// number of the last record, so that a crash in between, which leaves both the new snapshot and
+// the log, does not replay the records the snapshot already holds. It fails if a record could not
+// be applied to the tree, as the snapshot would not hold it
+func (j *Journal) Checkpoint() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return newError("Checkpoint", ErrClosed, nil)
+	}
+
+	if j.applied != j.seq {
+		return fmt.Errorf("the journal record %d was not applied to the tree, the journal must be reopened", j.applied+1)
+	}
+
+	tmp, err := ioutil.TempFile(j.dir, JOURNAL_SNAPSHOT_FILE+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := binary.Write(tmp, binary.LittleEndian, j.seq); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := j.rln.SaveTree(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(j.dir, JOURNAL_SNAPSHOT_FILE)); err != nil {
+		return err
+	}
+	syncDir(j.dir)
+
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	j.size = 0
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Close closes the log. The tree is left as is
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// syncDir makes a rename in `dir` durable. It is best effort, not every platform can sync a directory
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	_ = d.Sync()
+}
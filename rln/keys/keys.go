@@ -0,0 +1,54 @@
+// Package keys defines the membership key types of rln. It does not bind the rln lib, so that
+// packages handling keys, such as the keystore, can be built without cgo. The rln package
+// exposes the same types
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+var (
+	// ErrInvalidKey is returned when an identity key is not a field element
+	ErrInvalidKey = errors.New("invalid identity key")
+	// ErrInvalidCommitment is returned when an identity commitment is not a field element
+	ErrInvalidCommitment = errors.New("invalid identity commitment")
+)
+
+// IDKey is an identity key as defined in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
+type IDKey = [32]byte
+
+// IDCommitment is hash of identity key as defined in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
+type IDCommitment = [32]byte
+
+// MembershipIndex is the index of a member in the Merkle tree of a membership group
+type MembershipIndex = uint
+
+// MembershipKeyPair holds the identity key of a member and its identity commitment
+type MembershipKeyPair struct {
+	// user's identity key (a secret key) which is selected randomly
+	// see details in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
+	IDKey IDKey
+	// hash of user's identity key generated by
+	// Poseidon hash function implemented in rln lib
+	// more details in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
+	IDCommitment IDCommitment
+}
+
+// Validate checks that the identity key is a field element and that the identity commitment is
+// its Poseidon hash, which is how the rln lib computes it. The errors are of kind ErrInvalidKey
+// or ErrInvalidCommitment
+func (k MembershipKeyPair) Validate() error {
+	idCommitment, err := poseidon.HashBytes(k.IDKey)
+	if err != nil {
+		return fmt.Errorf("Validate: %w: %v", ErrInvalidKey, err)
+	}
+
+	if idCommitment != k.IDCommitment {
+		return fmt.Errorf("Validate: %w: the identity commitment does not match the identity key", ErrInvalidCommitment)
+	}
+
+	return nil
+}
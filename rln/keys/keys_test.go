@@ -0,0 +1,32 @@
+package keys
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	// a pair taken from rln.STATIC_GROUP_KEYS, which was generated by the rln lib
+	var key MembershipKeyPair
+	idKey, err := hex.DecodeString("d1ce3aea6cfb7be132d17e8d76fcbe4b7e34cef3979b4b905acfeff2f6d19724")
+	require.NoError(t, err)
+	copy(key.IDKey[:], idKey)
+	idCommitment, err := hex.DecodeString("be47b76297791f535f4b56f973a19f07ec22d4eede2a41ff23c696089938bb21")
+	require.NoError(t, err)
+	copy(key.IDCommitment[:], idCommitment)
+
+	require.NoError(t, key.Validate())
+
+	tampered := key
+	tampered.IDCommitment[0] ^= 1
+	require.True(t, errors.Is(tampered.Validate(), ErrInvalidCommitment))
+
+	var invalid IDKey
+	for i := range invalid {
+		invalid[i] = 0xff
+	}
+	require.True(t, errors.Is(MembershipKeyPair{IDKey: invalid}.Validate(), ErrInvalidKey))
+}
@@ -0,0 +1,517 @@
+// Package keystore stores rln membership credentials on disk, encrypted with a key derived from a password.
+//
+// The keystore is a versioned JSON file. A key is derived from the password with scrypt, each credential
+// is encrypted with AES-256-GCM, and a HMAC-SHA256 over the whole file detects a wrong password as well as
+// any modification, reordering or removal of its content. The package does not bind the rln lib and can be
+// built without cgo
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/waku-org/go-rln/rln/keys"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Application identifies the files created by this package
+	Application = "go-rln"
+	// Version is the version of the keystore format
+	Version = 1
+
+	kdfScrypt    = "scrypt"
+	cipherAESGCM = "aes-256-gcm"
+
+	// the derived key holds the encryption key followed by the mac key
+	keyLen = 32
+)
+
+var (
+	// ErrAuthFailed is returned when the keystore can not be authenticated, because the password
+	// is wrong or because the file was modified
+	ErrAuthFailed = errors.New("wrong password or tampered keystore")
+	// ErrMalformed is returned when the keystore file can not be parsed
+	ErrMalformed = errors.New("malformed keystore")
+	// ErrUnsupportedVersion is returned when the keystore was written with an unknown format
+	ErrUnsupportedVersion = errors.New("unsupported keystore version")
+	// ErrNotFound is returned when no credential matches a group and an identity commitment
+	ErrNotFound = errors.New("credential not found")
+	// ErrExists is returned when adding a credential whose group and identity commitment are already stored
+	ErrExists = errors.New("credential already exists")
+)
+
+// ScryptParams are the cost parameters of the key derivation. N must be a power of two, at most
+// MaxScryptN, and the memory and time costs are bounded by MaxScryptMemory and MaxScryptRP, so
+// that opening a crafted file can not exhaust the memory or the CPU
+type ScryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+var (
+	// StandardScrypt is the recommended cost, deriving the key takes about a second
+	StandardScrypt = ScryptParams{N: 1 << 18, R: 8, P: 1}
+	// LightScrypt is a cheaper cost for constrained devices and tests
+	LightScrypt = ScryptParams{N: 1 << 12, R: 8, P: 1}
+)
+
+const (
+	// MaxScryptN is the highest accepted N
+	MaxScryptN = 1 << 20
+	// MaxScryptMemory is the highest accepted memory cost in bytes, scrypt uses 128 * N * R bytes
+	MaxScryptMemory = 1 << 30
+	// MaxScryptRP is the highest accepted R * P, the time cost grows with N * R * P
+	MaxScryptRP = 1 << 6
+)
+
+// check checks that the parameters are within the bounds above
+func (p ScryptParams) check() error {
+	if p.N <= 1 || p.N&(p.N-1) != 0 || p.N > MaxScryptN {
+		return fmt.Errorf("scrypt N must be a power of two between 2 and %d, got %d", MaxScryptN, p.N)
+	}
+	if p.R <= 0 || p.P <= 0 || p.R > MaxScryptRP || p.P > MaxScryptRP || p.R*p.P > MaxScryptRP {
+		return fmt.Errorf("scrypt R and P must be positive and R * P at most %d, got %d and %d", MaxScryptRP, p.R, p.P)
+	}
+	// the product is computed on 64 bits, it reaches 2^33 and would overflow an int on 32 bit platforms
+	if 128*uint64(p.N)*uint64(p.R) > MaxScryptMemory {
+		return fmt.Errorf("scrypt N = %d and R = %d use more than %d bytes", p.N, p.R, MaxScryptMemory)
+	}
+	return nil
+}
+
+// Credential is a membership key pair together with its position in a membership group
+type Credential struct {
+	Keypair keys.MembershipKeyPair
+	Index   keys.MembershipIndex
+	// Group identifies the membership group, i.e. the address of the membership contract
+	Group string
+}
+
+// credentialJSON is the plaintext of an encrypted credential
+type credentialJSON struct {
+	IDKey        string `json:"idKey"`
+	IDCommitment string `json:"idCommitment"`
+	Index        uint64 `json:"index"`
+	Group        string `json:"group"`
+}
+
+type kdfParamsJSON struct {
+	ScryptParams
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// headerJSON holds the fields that describe how the credentials are protected. Its encoding
+// is authenticated along with every credential
+type headerJSON struct {
+	Application string        `json:"application"`
+	Version     int           `json:"version"`
+	KDF         string        `json:"kdf"`
+	KDFParams   kdfParamsJSON `json:"kdfparams"`
+	Cipher      string        `json:"cipher"`
+}
+
+type encryptedCredentialJSON struct {
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type fileJSON struct {
+	headerJSON
+	Credentials []encryptedCredentialJSON `json:"credentials"`
+	MAC         string                    `json:"mac"`
+}
+
+// Keystore is an encrypted set of credentials backed by a file. The credentials are decrypted
+// when the keystore is opened, and the file is rewritten on every change. It is safe for concurrent use
+type Keystore struct {
+	mu          sync.RWMutex
+	path        string
+	header      headerJSON
+	key         []byte
+	credentials []Credential
+}
+
+// Create creates an empty keystore at `path`, protected by `password`. It fails if the file exists
+// or if the parameters are out of the bounds documented on ScryptParams
+func Create(path string, password string, params ScryptParams) (*Keystore, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("keystore %s already exists", path)
+	}
+
+	ks := &Keystore{path: path}
+	if err := ks.setPassword(password, params); err != nil {
+		return nil, err
+	}
+
+	if err := ks.save(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Open reads the keystore at `path` and decrypts its credentials with `password`
+func Open(path string, password string) (*Keystore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fileJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if file.Application != Application || file.Version != Version {
+		return nil, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, file.Application, file.Version)
+	}
+
+	if file.KDF != kdfScrypt || file.Cipher != cipherAESGCM || file.KDFParams.DKLen != 2*keyLen {
+		return nil, fmt.Errorf("%w: unsupported kdf or cipher", ErrMalformed)
+	}
+
+	// the parameters are read from a file that is not authenticated yet, they are checked before
+	// any key is derived
+	if err := file.KDFParams.ScryptParams.check(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	salt, err := hex.DecodeString(file.KDFParams.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	key, err := deriveKey(password, salt, file.KDFParams.ScryptParams)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	ks := &Keystore{path: path, header: file.headerJSON, key: key}
+
+	mac, err := hex.DecodeString(file.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	expectedMAC, err := ks.mac(file.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expectedMAC) {
+		return nil, ErrAuthFailed
+	}
+
+	for _, c := range file.Credentials {
+		credential, err := ks.decrypt(c)
+		if err != nil {
+			return nil, err
+		}
+		ks.credentials = append(ks.credentials, credential)
+	}
+
+	return ks, nil
+}
+
+// Add stores a credential. A member can be registered in several groups, so credentials are
+// identified by their group and identity commitment, and Add fails if a credential of the same
+// group with the same identity commitment is stored. The key pair must be valid, see
+// MembershipKeyPair.Validate
+func (ks *Keystore) Add(credential Credential) error {
+	if err := credential.Keypair.Validate(); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.find(credential.Group, credential.Keypair.IDCommitment); ok {
+		return ErrExists
+	}
+
+	ks.credentials = append(ks.credentials, credential)
+	if err := ks.save(); err != nil {
+		ks.credentials = ks.credentials[:len(ks.credentials)-1]
+		return err
+	}
+
+	return nil
+}
+
+// List returns the stored credentials, in insertion order
+func (ks *Keystore) List() []Credential {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return append([]Credential(nil), ks.credentials...)
+}
+
+// Get returns the credential of the group with the given identity commitment
+func (ks *Keystore) Get(group string, commitment keys.IDCommitment) (Credential, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	i, ok := ks.find(group, commitment)
+	if !ok {
+		return Credential{}, ErrNotFound
+	}
+
+	return ks.credentials[i], nil
+}
+
+// Remove deletes the credential of the group with the given identity commitment
+func (ks *Keystore) Remove(group string, commitment keys.IDCommitment) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	i, ok := ks.find(group, commitment)
+	if !ok {
+		return ErrNotFound
+	}
+
+	previous := ks.credentials
+	ks.credentials = append(append([]Credential(nil), previous[:i]...), previous[i+1:]...)
+	if err := ks.save(); err != nil {
+		ks.credentials = previous
+		return err
+	}
+
+	return nil
+}
+
+// ChangePassword re-encrypts the keystore with a key derived from `password`, using
+// the same key derivation cost
+func (ks *Keystore) ChangePassword(password string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	previousHeader, previousKey := ks.header, ks.key
+	if err := ks.setPassword(password, ks.header.KDFParams.ScryptParams); err != nil {
+		return err
+	}
+
+	if err := ks.save(); err != nil {
+		ks.header, ks.key = previousHeader, previousKey
+		return err
+	}
+
+	return nil
+}
+
+func (ks *Keystore) find(group string, commitment keys.IDCommitment) (int, bool) {
+	for i, c := range ks.credentials {
+		if c.Group == group && c.Keypair.IDCommitment == commitment {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// setPassword derives a new key from the password with a fresh salt
+func (ks *Keystore) setPassword(password string, params ScryptParams) error {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	key, err := deriveKey(password, salt, params)
+	if err != nil {
+		return err
+	}
+
+	ks.key = key
+	ks.header = headerJSON{
+		Application: Application,
+		Version:     Version,
+		KDF:         kdfScrypt,
+		KDFParams: kdfParamsJSON{
+			ScryptParams: params,
+			DKLen:        2 * keyLen,
+			Salt:         hex.EncodeToString(salt),
+		},
+		Cipher: cipherAESGCM,
+	}
+
+	return nil
+}
+
+func deriveKey(password string, salt []byte, params ScryptParams) ([]byte, error) {
+	return scrypt.Key([]byte(password), salt, params.N, params.R, params.P, 2*keyLen)
+}
+
+// save encrypts the credentials and atomically replaces the keystore file
+func (ks *Keystore) save() error {
+	file := fileJSON{headerJSON: ks.header}
+	for _, c := range ks.credentials {
+		encrypted, err := ks.encrypt(c)
+		if err != nil {
+			return err
+		}
+		file.Credentials = append(file.Credentials, encrypted)
+	}
+
+	mac, err := ks.mac(file.Credentials)
+	if err != nil {
+		return err
+	}
+	file.MAC = hex.EncodeToString(mac)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(ks.path), filepath.Base(ks.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), ks.path)
+}
+
+// additionalData binds the ciphertexts to the header, so that it can not be altered
+func (ks *Keystore) additionalData() ([]byte, error) {
+	return json.Marshal(ks.header)
+}
+
+func (ks *Keystore) mac(credentials []encryptedCredentialJSON) ([]byte, error) {
+	ad, err := ks.additionalData()
+	if err != nil {
+		return nil, err
+	}
+
+	h := hmac.New(sha256.New, ks.key[keyLen:])
+	h.Write(ad)
+	for _, c := range credentials {
+		// the fields are separated so that bytes can not be moved from one to the other
+		h.Write([]byte{0})
+		h.Write([]byte(c.Nonce))
+		h.Write([]byte{0})
+		h.Write([]byte(c.Ciphertext))
+	}
+
+	return h.Sum(nil), nil
+}
+
+func (ks *Keystore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(ks.key[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (ks *Keystore) encrypt(c Credential) (encryptedCredentialJSON, error) {
+	plaintext, err := json.Marshal(credentialJSON{
+		IDKey:        hex.EncodeToString(c.Keypair.IDKey[:]),
+		IDCommitment: hex.EncodeToString(c.Keypair.IDCommitment[:]),
+		Index:        uint64(c.Index),
+		Group:        c.Group,
+	})
+	if err != nil {
+		return encryptedCredentialJSON{}, err
+	}
+
+	aead, err := ks.aead()
+	if err != nil {
+		return encryptedCredentialJSON{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return encryptedCredentialJSON{}, err
+	}
+
+	ad, err := ks.additionalData()
+	if err != nil {
+		return encryptedCredentialJSON{}, err
+	}
+
+	ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+
+	return encryptedCredentialJSON{
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	}, nil
+}
+
+func (ks *Keystore) decrypt(c encryptedCredentialJSON) (Credential, error) {
+	nonce, err := hex.DecodeString(c.Nonce)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	ciphertext, err := hex.DecodeString(c.Ciphertext)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	aead, err := ks.aead()
+	if err != nil {
+		return Credential{}, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return Credential{}, fmt.Errorf("%w: invalid nonce", ErrMalformed)
+	}
+
+	ad, err := ks.additionalData()
+	if err != nil {
+		return Credential{}, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return Credential{}, ErrAuthFailed
+	}
+
+	var aux credentialJSON
+	if err := json.Unmarshal(plaintext, &aux); err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	var keypair keys.MembershipKeyPair
+	idKey, err := hex.DecodeString(aux.IDKey)
+	if err != nil || len(idKey) != len(keypair.IDKey) {
+		return Credential{}, fmt.Errorf("%w: invalid identity key", ErrMalformed)
+	}
+	idCommitment, err := hex.DecodeString(aux.IDCommitment)
+	if err != nil || len(idCommitment) != len(keypair.IDCommitment) {
+		return Credential{}, fmt.Errorf("%w: invalid identity commitment", ErrMalformed)
+	}
+	copy(keypair.IDKey[:], idKey)
+	copy(keypair.IDCommitment[:], idCommitment)
+
+	return Credential{
+		Keypair: keypair,
+		Index:   keys.MembershipIndex(aux.Index),
+		Group:   aux.Group,
+	}, nil
+}
@@ -0,0 +1,268 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/waku-org/go-rln/rln/keys"
+)
+
+func testCredentials(t *testing.T) []Credential {
+	// (identity key, identity commitment) pairs taken from rln.STATIC_GROUP_KEYS
+	pairs := [][]string{
+		{"e9a4d05b1f539d65c59015a079ee89aabeafbcfc9734342d9559f81601e85417", "b74d3a5b3200ab1126fbee393496f33da497d4d9a7c56693f44d6155c0c34e13"},
+		{"27b2bfc25257e53819beaf36ce1070007e04e7aad2e440a1f1fc066f59a61123", "522ce51aff96041e79a8476f508fb9661f146f189e288f83cb4837517cfc0127"},
+		{"66392eaae6674267c55fe393d39443ba90317a709d6e8f92a9f3e4abc18eff1d", "e3dc235e48c1811943fc249fecd0f1415a50ebe839ccefb0bd820a76fb77ba2a"},
+	}
+
+	var credentials []Credential
+	for i, pair := range pairs {
+		var keypair keys.MembershipKeyPair
+		idKey, err := hex.DecodeString(pair[0])
+		require.NoError(t, err)
+		copy(keypair.IDKey[:], idKey)
+		idCommitment, err := hex.DecodeString(pair[1])
+		require.NoError(t, err)
+		copy(keypair.IDCommitment[:], idCommitment)
+		require.NoError(t, keypair.Validate())
+
+		credentials = append(credentials, Credential{
+			Keypair: keypair,
+			Index:   keys.MembershipIndex(i * 10),
+			Group:   "0x4252105670fe33d2947e8ead304969849e64f2a6",
+		})
+	}
+	return credentials
+}
+
+func createTestKeystore(t *testing.T) (string, []Credential) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	ks, err := Create(path, "password", LightScrypt)
+	require.NoError(t, err)
+
+	credentials := testCredentials(t)
+	for _, c := range credentials {
+		require.NoError(t, ks.Add(c))
+	}
+
+	return path, credentials
+}
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	ks, err := Create(path, "password", LightScrypt)
+	require.NoError(t, err)
+	require.Empty(t, ks.List())
+
+	_, err = Create(path, "password", LightScrypt)
+	require.Error(t, err)
+
+	credentials := testCredentials(t)
+	for _, c := range credentials {
+		require.NoError(t, ks.Add(c))
+	}
+	require.ErrorIs(t, ks.Add(credentials[0]), ErrExists)
+	require.Equal(t, credentials, ks.List())
+
+	// the key pair must be valid
+	invalid := credentials[0]
+	invalid.Keypair.IDCommitment = credentials[1].Keypair.IDCommitment
+	invalid.Group = "0x0000000000000000000000000000000000000000"
+	require.ErrorIs(t, ks.Add(invalid), keys.ErrInvalidCommitment)
+	invalid.Keypair.IDKey = [32]byte{0: 1, 31: 0xff}
+	require.ErrorIs(t, ks.Add(invalid), keys.ErrInvalidKey)
+	require.Equal(t, credentials, ks.List())
+
+	// the key material is not stored in plaintext
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	for _, c := range credentials {
+		require.NotContains(t, string(data), hex.EncodeToString(c.Keypair.IDKey[:]))
+		require.NotContains(t, string(data), hex.EncodeToString(c.Keypair.IDCommitment[:]))
+	}
+
+	ks, err = Open(path, "password")
+	require.NoError(t, err)
+	require.Equal(t, credentials, ks.List())
+
+	// the same member can be registered in another group
+	other := credentials[1]
+	other.Group = "0x0000000000000000000000000000000000000001"
+	other.Index = 7
+	require.NoError(t, ks.Add(other))
+
+	group := credentials[1].Group
+	c, err := ks.Get(group, credentials[1].Keypair.IDCommitment)
+	require.NoError(t, err)
+	require.Equal(t, credentials[1], c)
+	c, err = ks.Get(other.Group, other.Keypair.IDCommitment)
+	require.NoError(t, err)
+	require.Equal(t, other, c)
+	_, err = ks.Get("0x0000000000000000000000000000000000000002", other.Keypair.IDCommitment)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, ks.Remove(group, credentials[1].Keypair.IDCommitment))
+	_, err = ks.Get(group, credentials[1].Keypair.IDCommitment)
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, ks.Remove(group, credentials[1].Keypair.IDCommitment), ErrNotFound)
+	_, err = ks.Get(other.Group, other.Keypair.IDCommitment)
+	require.NoError(t, err)
+
+	ks, err = Open(path, "password")
+	require.NoError(t, err)
+	require.Equal(t, []Credential{credentials[0], credentials[2], other}, ks.List())
+}
+
+func TestChangePassword(t *testing.T) {
+	path, credentials := createTestKeystore(t)
+
+	ks, err := Open(path, "password")
+	require.NoError(t, err)
+	require.NoError(t, ks.ChangePassword("new password"))
+
+	_, err = Open(path, "password")
+	require.ErrorIs(t, err, ErrAuthFailed)
+
+	ks, err = Open(path, "new password")
+	require.NoError(t, err)
+	require.Equal(t, credentials, ks.List())
+}
+
+func TestWrongPassword(t *testing.T) {
+	path, _ := createTestKeystore(t)
+
+	_, err := Open(path, "wrong password")
+	require.ErrorIs(t, err, ErrAuthFailed)
+
+	// an empty keystore is authenticated as well
+	path = filepath.Join(t.TempDir(), "empty.json")
+	_, err = Create(path, "password", LightScrypt)
+	require.NoError(t, err)
+	_, err = Open(path, "wrong password")
+	require.ErrorIs(t, err, ErrAuthFailed)
+}
+
+// flipHex changes the first character of a hex string
+func flipHex(s string) string {
+	if s[0] == '0' {
+		return "1" + s[1:]
+	}
+	return "0" + s[1:]
+}
+
+func TestTamperDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(f map[string]interface{})
+		err    error
+	}{
+		{"ciphertext", func(f map[string]interface{}) {
+			c := f["credentials"].([]interface{})[1].(map[string]interface{})
+			c["ciphertext"] = flipHex(c["ciphertext"].(string))
+		}, ErrAuthFailed},
+		{"nonce", func(f map[string]interface{}) {
+			c := f["credentials"].([]interface{})[0].(map[string]interface{})
+			c["nonce"] = flipHex(c["nonce"].(string))
+		}, ErrAuthFailed},
+		{"removed credential", func(f map[string]interface{}) {
+			f["credentials"] = f["credentials"].([]interface{})[1:]
+		}, ErrAuthFailed},
+		{"reordered credentials", func(f map[string]interface{}) {
+			c := f["credentials"].([]interface{})
+			c[0], c[1] = c[1], c[0]
+		}, ErrAuthFailed},
+		{"mac", func(f map[string]interface{}) {
+			f["mac"] = flipHex(f["mac"].(string))
+		}, ErrAuthFailed},
+		{"salt", func(f map[string]interface{}) {
+			p := f["kdfparams"].(map[string]interface{})
+			p["salt"] = flipHex(p["salt"].(string))
+		}, ErrAuthFailed},
+		{"version", func(f map[string]interface{}) {
+			f["version"] = Version + 1
+		}, ErrUnsupportedVersion},
+		{"application", func(f map[string]interface{}) {
+			f["application"] = "other"
+		}, ErrUnsupportedVersion},
+		{"cipher", func(f map[string]interface{}) {
+			f["cipher"] = "aes-128-ctr"
+		}, ErrMalformed},
+		// the scrypt parameters are bounded before the key is derived, a crafted file would
+		// otherwise make Open allocate terabytes or run for hours
+		{"scrypt n", func(f map[string]interface{}) {
+			f["kdfparams"].(map[string]interface{})["n"] = 1e12
+		}, ErrMalformed},
+		{"scrypt n not a power of two", func(f map[string]interface{}) {
+			f["kdfparams"].(map[string]interface{})["n"] = 3 << 10
+		}, ErrMalformed},
+		{"scrypt memory", func(f map[string]interface{}) {
+			p := f["kdfparams"].(map[string]interface{})
+			p["n"], p["r"] = MaxScryptN, 16
+		}, ErrMalformed},
+		{"scrypt r * p", func(f map[string]interface{}) {
+			p := f["kdfparams"].(map[string]interface{})
+			p["r"], p["p"] = 8, 1<<30
+		}, ErrMalformed},
+		{"scrypt p", func(f map[string]interface{}) {
+			f["kdfparams"].(map[string]interface{})["p"] = 0
+		}, ErrMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, _ := createTestKeystore(t)
+
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+
+			var f map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &f))
+			test.tamper(f)
+			data, err = json.Marshal(f)
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+			_, err = Open(path, "password")
+			require.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestMalformedFile(t *testing.T) {
+	path, _ := createTestKeystore(t)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, []byte(strings.TrimSuffix(string(data), "}")), 0600))
+
+	_, err = Open(path, "password")
+	require.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestScryptParams(t *testing.T) {
+	for _, params := range []ScryptParams{StandardScrypt, LightScrypt, {N: MaxScryptN, R: 8, P: 1}, {N: 2, R: 1, P: MaxScryptRP}} {
+		require.NoError(t, params.check(), "%+v", params)
+	}
+
+	for _, params := range []ScryptParams{
+		{N: 1, R: 8, P: 1},
+		{N: 2 * MaxScryptN, R: 8, P: 1},
+		{N: 1<<10 + 1, R: 8, P: 1},
+		{N: MaxScryptN, R: 9, P: 1},
+		{N: 1 << 12, R: 8, P: 9},
+		{N: 1 << 12, R: 0, P: 1},
+		{N: 1 << 12, R: -8, P: -1},
+	} {
+		require.Error(t, params.check(), "%+v", params)
+	}
+
+	_, err := Create(filepath.Join(t.TempDir(), "keystore.json"), "password", ScryptParams{N: 1 << 30, R: 8, P: 1})
+	require.Error(t, err)
+}
@@ -0,0 +1,283 @@
+// Package merkle implements a Poseidon incremental Merkle tree that mirrors the membership tree
+// kept by the rln lib, giving access to the leaves and authentication paths of the members
+package merkle
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+var (
+	// ErrTreeFull is returned when inserting into a tree that has no empty leaves left
+	ErrTreeFull = errors.New("merkle tree is full")
+	// ErrInvalidIndex is returned when an index is outside of the tree
+	ErrInvalidIndex = errors.New("invalid leaf index")
+)
+
+// Path is the authentication path of a leaf: the siblings of the nodes on the way from
+// the leaf to the root, starting at the leaf level
+type Path struct {
+	Index    uint
+	Siblings [][32]byte
+}
+
+// Tree is a sparse Merkle tree of a fixed depth, in which the leaves are appended from left to right.
+// Empty leaves are zero, and a node is the Poseidon hash of its two children, the same
+// construction used by the rln lib. A Tree is not safe for concurrent use
+type Tree struct {
+	depth     int
+	nextIndex uint
+	size      uint
+
+	// nodes holds the non empty nodes of each level, the leaves are stored at level 0
+	// and the root at level `depth`
+	nodes []map[uint]fr.Element
+	// zeros holds the value of an empty node of each level
+	zeros []fr.Element
+}
+
+// New creates an empty tree of the given depth
+func New(depth int) (*Tree, error) {
+	if depth <= 0 || depth >= 64 {
+		return nil, fmt.Errorf("unsupported tree depth %d", depth)
+	}
+
+	t := &Tree{
+		depth: depth,
+		nodes: make([]map[uint]fr.Element, depth+1),
+		zeros: make([]fr.Element, depth+1),
+	}
+
+	for i := range t.nodes {
+		t.nodes[i] = make(map[uint]fr.Element)
+	}
+
+	for i := 0; i < depth; i++ {
+		t.zeros[i+1] = poseidon.Hash(t.zeros[i], t.zeros[i])
+	}
+
+	return t, nil
+}
+
+// Depth returns the depth of the tree
+func (t *Tree) Depth() int {
+	return t.depth
+}
+
+// Capacity returns the number of leaves of the tree
+func (t *Tree) Capacity() uint64 {
+	return uint64(1) << uint(t.depth)
+}
+
+// NextIndex returns the index of the leaf used by the next insertion
+func (t *Tree) NextIndex() uint {
+	return t.nextIndex
+}
+
+// Size returns the number of leaves in use, that is, the non zero leaves
+func (t *Tree) Size() uint {
+	return t.size
+}
+
+// Root returns the root of the tree
+func (t *Tree) Root() [32]byte {
+	return poseidon.ToBytes(t.node(t.depth, 0))
+}
+
+// Insert sets the leaf at NextIndex and returns its index
+func (t *Tree) Insert(leaf [32]byte) (uint, error) {
+	if uint64(t.nextIndex) >= t.Capacity() {
+		return 0, ErrTreeFull
+	}
+
+	value, err := poseidon.FromBytes(leaf)
+	if err != nil {
+		return 0, err
+	}
+
+	index := t.nextIndex
+	t.update(index, value)
+	t.nextIndex++
+
+	return index, nil
+}
+
+// SetLeaves sets the leaves from `start` onwards, recomputing each level of the tree once for
+// the whole range. NextIndex is moved past the last leaf if it precedes it. The tree is not
+// modified if a leaf is not a field element
+func (t *Tree) SetLeaves(start uint, leaves [][32]byte) error {
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	end := uint64(start) + uint64(len(leaves))
+	if end > t.Capacity() {
+		return ErrInvalidIndex
+	}
+
+	values := make([]fr.Element, len(leaves))
+	for i, leaf := range leaves {
+		value, err := poseidon.FromBytes(leaf)
+		if err != nil {
+			return fmt.Errorf("leaf %d: %w", start+uint(i), err)
+		}
+		values[i] = value
+	}
+
+	for i, value := range values {
+		index := start + uint(i)
+		previous := t.node(0, index)
+		if !previous.IsZero() {
+			t.size--
+		}
+		if !value.IsZero() {
+			t.size++
+		}
+		t.setNode(0, index, value)
+	}
+
+	first, last := start, uint(end-1)
+	for level := 0; level < t.depth; level++ {
+		first, last = first>>1, last>>1
+		for index := first; index <= last; index++ {
+			t.setNode(level+1, index, poseidon.Hash(t.node(level, 2*index), t.node(level, 2*index+1)))
+		}
+	}
+
+	if uint(end) > t.nextIndex {
+		t.nextIndex = uint(end)
+	}
+
+	return nil
+}
+
+// Delete replaces the leaf at `index` with a zero leaf
+func (t *Tree) Delete(index uint) error {
+	if !t.validIndex(index) {
+		return ErrInvalidIndex
+	}
+
+	t.update(index, fr.Element{})
+
+	return nil
+}
+
+// Leaf returns the leaf at `index`
+func (t *Tree) Leaf(index uint) ([32]byte, error) {
+	if !t.validIndex(index) {
+		return [32]byte{}, ErrInvalidIndex
+	}
+
+	return poseidon.ToBytes(t.node(0, index)), nil
+}
+
+// IndexOf returns the index of the first leaf equal to `leaf`. Zero leaves are not looked up
+func (t *Tree) IndexOf(leaf [32]byte) (uint, bool) {
+	value, err := poseidon.FromBytes(leaf)
+	if err != nil || value.IsZero() {
+		return 0, false
+	}
+
+	found := false
+	var result uint
+	for index, l := range t.nodes[0] {
+		if l.Equal(&value) && (!found || index < result) {
+			result = index
+			found = true
+		}
+	}
+
+	return result, found
+}
+
+// Path returns the authentication path of the leaf at `index`
+func (t *Tree) Path(index uint) (Path, error) {
+	if !t.validIndex(index) {
+		return Path{}, ErrInvalidIndex
+	}
+
+	path := Path{
+		Index:    index,
+		Siblings: make([][32]byte, t.depth),
+	}
+
+	for level := 0; level < t.depth; level++ {
+		path.Siblings[level] = poseidon.ToBytes(t.node(level, index^1))
+		index >>= 1
+	}
+
+	return path, nil
+}
+
+// VerifyPath checks that `leaf` is a leaf of the tree with the given root, at the position
+// described by the authentication path
+func VerifyPath(leaf [32]byte, path Path, root [32]byte) bool {
+	node, err := poseidon.FromBytes(leaf)
+	if err != nil {
+		return false
+	}
+
+	if len(path.Siblings) == 0 || len(path.Siblings) >= 64 || uint64(path.Index)>>uint(len(path.Siblings)) != 0 {
+		return false
+	}
+
+	index := path.Index
+	for _, s := range path.Siblings {
+		sibling, err := poseidon.FromBytes(s)
+		if err != nil {
+			return false
+		}
+
+		if index&1 == 0 {
+			node = poseidon.Hash(node, sibling)
+		} else {
+			node = poseidon.Hash(sibling, node)
+		}
+		index >>= 1
+	}
+
+	return poseidon.ToBytes(node) == root
+}
+
+func (t *Tree) validIndex(index uint) bool {
+	return uint64(index) < t.Capacity()
+}
+
+// node returns the node at position `index` of the level
+func (t *Tree) node(level int, index uint) fr.Element {
+	if n, ok := t.nodes[level][index]; ok {
+		return n
+	}
+	return t.zeros[level]
+}
+
+// setNode stores a node, only the nodes that differ from an empty node are kept
+func (t *Tree) setNode(level int, index uint, value fr.Element) {
+	if value.Equal(&t.zeros[level]) {
+		delete(t.nodes[level], index)
+	} else {
+		t.nodes[level][index] = value
+	}
+}
+
+// update sets a leaf and recomputes the nodes on its path to the root
+func (t *Tree) update(index uint, value fr.Element) {
+	previous := t.node(0, index)
+	if !previous.IsZero() {
+		t.size--
+	}
+	if !value.IsZero() {
+		t.size++
+	}
+
+	t.setNode(0, index, value)
+	for level := 0; level < t.depth; level++ {
+		left := t.node(level, index&^1)
+		right := t.node(level, index|1)
+		index >>= 1
+		t.setNode(level+1, index, poseidon.Hash(left, right))
+	}
+}
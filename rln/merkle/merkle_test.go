@@ -0,0 +1,188 @@
+package merkle_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/waku-org/go-rln/rln"
+	"github.com/waku-org/go-rln/rln/merkle"
+)
+
+func staticGroupCommitments(t *testing.T) [][32]byte {
+	var commitments [][32]byte
+	for _, pair := range rln.STATIC_GROUP_KEYS {
+		b, err := hex.DecodeString(pair[1])
+		require.NoError(t, err)
+		commitments = append(commitments, rln.Bytes32(b))
+	}
+	return commitments
+}
+
+func TestStaticGroupRoot(t *testing.T) {
+	tree, err := merkle.New(rln.MERKLE_TREE_DEPTH)
+	require.NoError(t, err)
+
+	for i, c := range staticGroupCommitments(t) {
+		index, err := tree.Insert(c)
+		require.NoError(t, err)
+		require.Equal(t, uint(i), index)
+	}
+
+	root := tree.Root()
+	require.Equal(t, rln.STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+	require.Equal(t, uint(rln.STATIC_GROUP_SIZE), tree.NextIndex())
+	require.Equal(t, uint(rln.STATIC_GROUP_SIZE), tree.Size())
+}
+
+func TestPaths(t *testing.T) {
+	tree, err := merkle.New(rln.MERKLE_TREE_DEPTH)
+	require.NoError(t, err)
+
+	commitments := staticGroupCommitments(t)[:10]
+	for _, c := range commitments {
+		_, err := tree.Insert(c)
+		require.NoError(t, err)
+	}
+
+	root := tree.Root()
+	for i, c := range commitments {
+		leaf, err := tree.Leaf(uint(i))
+		require.NoError(t, err)
+		require.Equal(t, c, leaf)
+
+		index, ok := tree.IndexOf(c)
+		require.True(t, ok)
+		require.Equal(t, uint(i), index)
+
+		path, err := tree.Path(uint(i))
+		require.NoError(t, err)
+		require.Len(t, path.Siblings, rln.MERKLE_TREE_DEPTH)
+		require.True(t, merkle.VerifyPath(c, path, root))
+
+		// the path does not prove other leaves or positions
+		require.False(t, merkle.VerifyPath(commitments[(i+1)%len(commitments)], path, root))
+		moved := path
+		moved.Index = uint(i) ^ 1
+		require.False(t, merkle.VerifyPath(c, moved, root))
+	}
+
+	// empty leaves have a path as well
+	path, err := tree.Path(uint(len(commitments)))
+	require.NoError(t, err)
+	require.True(t, merkle.VerifyPath([32]byte{}, path, root))
+
+	// paths become stale when the tree changes
+	path, err = tree.Path(0)
+	require.NoError(t, err)
+	require.NoError(t, tree.Delete(3))
+	require.False(t, merkle.VerifyPath(commitments[0], path, tree.Root()))
+}
+
+func TestDeletion(t *testing.T) {
+	tree, err := merkle.New(rln.MERKLE_TREE_DEPTH)
+	require.NoError(t, err)
+
+	emptyRoot := tree.Root()
+
+	commitment := staticGroupCommitments(t)[0]
+	_, err = tree.Insert(commitment)
+	require.NoError(t, err)
+	require.NotEqual(t, emptyRoot, tree.Root())
+
+	require.NoError(t, tree.Delete(0))
+	require.Equal(t, emptyRoot, tree.Root())
+
+	leaf, err := tree.Leaf(0)
+	require.NoError(t, err)
+	require.Equal(t, [32]byte{}, leaf)
+	_, ok := tree.IndexOf(commitment)
+	require.False(t, ok)
+
+	// deleted leaves are not reused
+	require.Equal(t, uint(1), tree.NextIndex())
+	require.Equal(t, uint(0), tree.Size())
+}
+
+func TestBounds(t *testing.T) {
+	_, err := merkle.New(0)
+	require.Error(t, err)
+
+	tree, err := merkle.New(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), tree.Capacity())
+
+	commitments := staticGroupCommitments(t)
+	for i := 0; i < 4; i++ {
+		_, err := tree.Insert(commitments[i])
+		require.NoError(t, err)
+	}
+
+	_, err = tree.Insert(commitments[4])
+	require.ErrorIs(t, err, merkle.ErrTreeFull)
+
+	_, err = tree.Leaf(4)
+	require.ErrorIs(t, err, merkle.ErrInvalidIndex)
+	_, err = tree.Path(4)
+	require.ErrorIs(t, err, merkle.ErrInvalidIndex)
+	require.ErrorIs(t, tree.Delete(4), merkle.ErrInvalidIndex)
+
+	var nonCanonical [32]byte
+	for i := range nonCanonical {
+		nonCanonical[i] = 0xff
+	}
+	tree, _ = merkle.New(2)
+	_, err = tree.Insert(nonCanonical)
+	require.Error(t, err)
+	require.Equal(t, uint(0), tree.NextIndex())
+}
+
+func TestSetLeaves(t *testing.T) {
+	commitments := staticGroupCommitments(t)
+
+	tree, err := merkle.New(rln.MERKLE_TREE_DEPTH)
+	require.NoError(t, err)
+	require.NoError(t, tree.SetLeaves(0, commitments[:37]))
+	require.NoError(t, tree.SetLeaves(37, commitments[37:]))
+	require.NoError(t, tree.SetLeaves(0, nil))
+
+	root := tree.Root()
+	require.Equal(t, rln.STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+	require.Equal(t, uint(rln.STATIC_GROUP_SIZE), tree.NextIndex())
+	require.Equal(t, uint(rln.STATIC_GROUP_SIZE), tree.Size())
+
+	// a range past NextIndex gives the same tree as inserting zero leaves
+	gap, err := merkle.New(4)
+	require.NoError(t, err)
+	require.NoError(t, gap.SetLeaves(5, commitments[:3]))
+
+	sequential, err := merkle.New(4)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := sequential.Insert([32]byte{})
+		require.NoError(t, err)
+	}
+	for _, c := range commitments[:3] {
+		_, err := sequential.Insert(c)
+		require.NoError(t, err)
+	}
+	require.Equal(t, sequential.Root(), gap.Root())
+	require.Equal(t, uint(8), gap.NextIndex())
+	require.Equal(t, uint(3), gap.Size())
+
+	// overwriting leaves keeps NextIndex
+	require.NoError(t, gap.SetLeaves(0, commitments[3:5]))
+	require.Equal(t, uint(8), gap.NextIndex())
+	require.Equal(t, uint(5), gap.Size())
+
+	require.ErrorIs(t, gap.SetLeaves(15, commitments[:2]), merkle.ErrInvalidIndex)
+
+	invalid := [32]byte{}
+	for i := range invalid {
+		invalid[i] = 0xff
+	}
+	root = gap.Root()
+	require.Error(t, gap.SetLeaves(8, [][32]byte{commitments[0], invalid}))
+	require.Equal(t, root, gap.Root())
+	require.Equal(t, uint(8), gap.NextIndex())
+}
@@ -0,0 +1,154 @@
+package rln
+
+import "sync"
+
+// ProofStatus is the classification of a proof by a NullifierLog
+type ProofStatus int
+
+const (
+	// ProofFresh means that no proof with the same nullifier was seen in the epoch
+	ProofFresh ProofStatus = iota
+	// ProofDuplicate means that the same proof (same nullifier and shares) was already seen
+	// in the epoch, i.e. the message was relayed more than once
+	ProofDuplicate
+	// ProofDoubleSignal means that a proof with the same nullifier but different shares was
+	// already seen in the epoch, i.e. the member published more than one message in the epoch
+	ProofDoubleSignal
+	// ProofOutOfWindow means that the epoch is not within the window of the last pruned epoch,
+	// the proof is not recorded
+	ProofOutOfWindow
+)
+
+func (s ProofStatus) String() string {
+	switch s {
+	case ProofFresh:
+		return "fresh"
+	case ProofDuplicate:
+		return "duplicate"
+	case ProofDoubleSignal:
+		return "double signal"
+	case ProofOutOfWindow:
+		return "out of window"
+	default:
+		return "unknown"
+	}
+}
+
+// NullifierLog records the ProofMetadata of the proofs received in recent epochs, to detect
+// members exceeding the rate limit of one message per epoch. It is safe for concurrent use
+type NullifierLog struct {
+	mu sync.Mutex
+	// window is the number of epochs kept by Prune
+	window uint64
+	// current is the epoch of the last Prune, if pruned is set
+	current Epoch
+	pruned  bool
+	// epochs holds the distinct proofs seen for each nullifier of each epoch
+	epochs map[Epoch]map[Nullifier][]ProofMetadata
+}
+
+// NewNullifierLog creates a NullifierLog that keeps the proofs of the epochs that are less than
+// `window` epochs away from the epoch it was last pruned at, before or after it
+func NewNullifierLog(window uint64) *NullifierLog {
+	if window == 0 {
+		window = 1
+	}
+
+	return &NullifierLog{
+		window: window,
+		epochs: make(map[Epoch]map[Nullifier][]ProofMetadata),
+	}
+}
+
+// Check classifies a proof received in `epoch` without recording it. For a double signal,
+// the previously seen proof with the same nullifier is returned as well
+func (l *NullifierLog) Check(epoch Epoch, metadata ProofMetadata) (ProofStatus, ProofMetadata) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.check(epoch, metadata)
+}
+
+// Add classifies a proof received in `epoch` and records it. For a double signal, the
+// previously seen proof with the same nullifier is returned as well. Once the log was pruned,
+// the proofs of the epochs Prune would remove are not recorded, so that the epochs a sender
+// picks can not grow the log. Before the first Prune, every epoch is recorded
+func (l *NullifierLog) Add(epoch Epoch, metadata ProofMetadata) (ProofStatus, ProofMetadata) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status, previous := l.check(epoch, metadata)
+	if status == ProofDuplicate || status == ProofOutOfWindow {
+		return status, previous
+	}
+
+	nullifiers, ok := l.epochs[epoch]
+	if !ok {
+		nullifiers = make(map[Nullifier][]ProofMetadata)
+		l.epochs[epoch] = nullifiers
+	}
+	nullifiers[metadata.Nullifier] = append(nullifiers[metadata.Nullifier], metadata)
+
+	return status, previous
+}
+
+func (l *NullifierLog) check(epoch Epoch, metadata ProofMetadata) (ProofStatus, ProofMetadata) {
+	if l.pruned && l.outOfWindow(epoch, l.current) {
+		return ProofOutOfWindow, ProofMetadata{}
+	}
+
+	seen := l.epochs[epoch][metadata.Nullifier]
+	if len(seen) == 0 {
+		return ProofFresh, ProofMetadata{}
+	}
+
+	for _, m := range seen {
+		if m.Equals(metadata) {
+			return ProofDuplicate, m
+		}
+	}
+
+	return ProofDoubleSignal, seen[0]
+}
+
+// Prune removes the proofs of the epochs that are `window` or more epochs older or newer than
+// `current`, and bounds the epochs recorded by Add until the next Prune
+func (l *NullifierLog) Prune(current Epoch) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.current, l.pruned = current, true
+	for epoch := range l.epochs {
+		if l.outOfWindow(epoch, current) {
+			delete(l.epochs, epoch)
+		}
+	}
+}
+
+// outOfWindow reports whether `epoch` is `window` or more epochs away from `current`. Epochs
+// with high bytes beyond the 8 bytes of an uint64 are never in the window
+func (l *NullifierLog) outOfWindow(epoch Epoch, current Epoch) bool {
+	if epoch != ToEpoch(epoch.Uint64()) {
+		return true
+	}
+
+	e, c := epoch.Uint64(), current.Uint64()
+	if e > c {
+		return e-c >= l.window
+	}
+	return c-e >= l.window
+}
+
+// Len returns the number of proofs recorded
+func (l *NullifierLog) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, nullifiers := range l.epochs {
+		for _, seen := range nullifiers {
+			n += len(seen)
+		}
+	}
+	return n
+}
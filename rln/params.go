@@ -0,0 +1,222 @@
+package rln
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Params holds the zkSNARK parameters of the rln circuit, as generated by https://github.com/kilic/rln.
+// Params are immutable, and the instances parsed from the same content are shared
+type Params struct {
+	data []byte
+	hash [32]byte
+}
+
+// paramsDepths records the depth of the Merkle tree the known parameters were generated for,
+// indexed by fingerprint
+var paramsDepths = struct {
+	sync.RWMutex
+	depths map[[32]byte]int
+}{
+	depths: map[[32]byte]int{
+		// rln/testdata/parameters.key
+		mustDecodeFingerprint("be13c7c155313b42a4efbbdd590f3634d7b9a203f554ad604b8eec85c5ebda7a"): 20,
+	},
+}
+
+// paramsCache holds all the parameters loaded by the process, so that the RLN instances created
+// with the same parameters share one copy
+var paramsCache = struct {
+	sync.Mutex
+	params map[[32]byte]*Params
+}{
+	params: make(map[[32]byte]*Params),
+}
+
+// LoadParams reads the parameters stored in a file
+func LoadParams(path string) (*Params, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, newError("LoadParams", ErrInvalidParams, err)
+	}
+	return newParams("LoadParams", data)
+}
+
+// ParseParams reads parameters until EOF
+func ParseParams(r io.Reader) (*Params, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, newError("ParseParams", ErrInvalidParams, err)
+	}
+	return newParams("ParseParams", data)
+}
+
+func newParams(op string, data []byte) (*Params, error) {
+	hash := sha256.Sum256(data)
+
+	paramsCache.Lock()
+	defer paramsCache.Unlock()
+
+	if p, ok := paramsCache.params[hash]; ok {
+		return p, nil
+	}
+
+	if err := checkParamsLayout(data); err != nil {
+		return nil, newError(op, ErrInvalidParams, err)
+	}
+
+	p := &Params{
+		data: append([]byte(nil), data...),
+		hash: hash,
+	}
+	paramsCache.params[hash] = p
+
+	return p, nil
+}
+
+// Bytes returns the content of the parameters, it must not be modified
+func (p *Params) Bytes() []byte {
+	return p.data
+}
+
+// Hash returns the SHA-256 hash of the parameters
+func (p *Params) Hash() [32]byte {
+	return p.hash
+}
+
+// Fingerprint returns the hex encoded SHA-256 hash of the parameters
+func (p *Params) Fingerprint() string {
+	return hex.EncodeToString(p.hash[:])
+}
+
+// CheckFingerprint checks that the parameters have the expected hex encoded SHA-256 hash
+func (p *Params) CheckFingerprint(expected string) error {
+	if !strings.EqualFold(strings.TrimPrefix(expected, "0x"), p.Fingerprint()) {
+		return newError("CheckFingerprint", ErrInvalidParams, fmt.Errorf("expected fingerprint %s, got %s", expected, p.Fingerprint()))
+	}
+	return nil
+}
+
+// Depth returns the depth of the Merkle tree the parameters were generated for, if it is known.
+// See RegisterParamsDepth
+func (p *Params) Depth() (int, bool) {
+	paramsDepths.RLock()
+	defer paramsDepths.RUnlock()
+
+	depth, ok := paramsDepths.depths[p.hash]
+	return depth, ok
+}
+
+// RegisterParamsDepth records the depth of the Merkle tree the parameters with the given hex
+// encoded SHA-256 fingerprint were generated for. The depth is not part of the parameters, and
+// instances created with a different depth are rejected
+func RegisterParamsDepth(fingerprint string, depth int) error {
+	hash, err := decodeFingerprint(fingerprint)
+	if err != nil {
+		return newError("RegisterParamsDepth", ErrInvalidParams, err)
+	}
+
+	if depth <= 0 || depth >= 64 {
+		return newError("RegisterParamsDepth", ErrInvalidParams, fmt.Errorf("unsupported tree depth %d", depth))
+	}
+
+	paramsDepths.Lock()
+	defer paramsDepths.Unlock()
+
+	paramsDepths.depths[hash] = depth
+
+	return nil
+}
+
+// checkDepth fails when the parameters are known to be generated for another depth
+func (p *Params) checkDepth(depth int) error {
+	if known, ok := p.Depth(); ok && known != depth {
+		return fmt.Errorf("the parameters %s were generated for a Merkle tree of depth %d, not %d", p.Fingerprint()[:16], known, depth)
+	}
+	return nil
+}
+
+func decodeFingerprint(fingerprint string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(strings.TrimPrefix(fingerprint, "0x"))
+	if err != nil {
+		return hash, fmt.Errorf("invalid fingerprint: %w", err)
+	}
+	if len(b) != len(hash) {
+		return hash, fmt.Errorf("invalid fingerprint: expected %d bytes", len(hash))
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func mustDecodeFingerprint(fingerprint string) [32]byte {
+	hash, err := decodeFingerprint(fingerprint)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// Sizes of the uncompressed curve points of the parameters
+const (
+	g1Size = 64
+	g2Size = 128
+	// the verifying key starts with alpha_g1, beta_g1, beta_g2, gamma_g2, delta_g1 and delta_g2
+	vkSize = 3*g1Size + 3*g2Size
+	// the rln circuit has 5 public inputs: the share x, the epoch, the share y, the nullifier and the root
+	publicInputs = 5
+)
+
+// checkParamsLayout checks that the parameters are made of the groth16 verifying key of the rln
+// circuit followed by the h, l, a, b_g1 and b_g2 queries of the proving key, each query being
+// prefixed by its length. It detects truncated and corrupted files, not invalid points
+func checkParamsLayout(data []byte) error {
+	offset := vkSize
+
+	readPoints := func(name string, size int) (uint32, error) {
+		if len(data) < offset+4 {
+			return 0, fmt.Errorf("truncated parameters: missing the length of %s", name)
+		}
+		n := binary.BigEndian.Uint32(data[offset:])
+		offset += 4
+		if uint64(len(data)-offset) < uint64(n)*uint64(size) {
+			return 0, fmt.Errorf("truncated parameters: %s holds %d points", name, n)
+		}
+		offset += int(n) * size
+		return n, nil
+	}
+
+	if len(data) < offset {
+		return errors.New("truncated parameters: incomplete verifying key")
+	}
+
+	ic, err := readPoints("ic", g1Size)
+	if err != nil {
+		return err
+	}
+	if ic != publicInputs+1 {
+		return fmt.Errorf("the parameters are for a circuit with %d public inputs, not the rln circuit", int64(ic)-1)
+	}
+
+	for _, query := range []struct {
+		name string
+		size int
+	}{{"h", g1Size}, {"l", g1Size}, {"a", g1Size}, {"b_g1", g1Size}, {"b_g2", g2Size}} {
+		if _, err := readPoints(query.name, query.size); err != nil {
+			return err
+		}
+	}
+
+	if offset != len(data) {
+		return fmt.Errorf("corrupted parameters: %d unexpected trailing bytes", len(data)-offset)
+	}
+
+	return nil
+}
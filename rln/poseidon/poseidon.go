@@ -0,0 +1,152 @@
+// Package poseidon implements the Poseidon hash function over the BN254 scalar field with the
+// parameters used by https://github.com/kilic/rln, so that identity commitments and Merkle tree
+// nodes can be computed in Go and match the ones computed by the rln lib
+package poseidon
+
+import (
+	"errors"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"golang.org/x/crypto/blake2s"
+)
+
+// the rln lib instantiates the hasher as PoseidonParams::new(8, 55, 3, None, None, None)
+// see https://github.com/kilic/rln/blob/7ac74183f8b69b399e3bc96c1ae8ab61c026dc43/src/poseidon.rs
+const (
+	// Width is the size of the state, and the maximum number of inputs of Hash
+	Width         = 3
+	fullRounds    = 8
+	partialRounds = 55
+)
+
+// ErrNonCanonical is returned when a 32 byte value is not lower than the field modulus
+var ErrNonCanonical = errors.New("value is not a canonical field element")
+
+var (
+	roundConstants []fr.Element
+	mdsMatrix      [Width][Width]fr.Element
+)
+
+func init() {
+	roundConstants = generateConstants([]byte("drlnhdsc"), fullRounds+partialRounds)
+
+	// the entries of the mds matrix are 1 / (x_i + y_j)
+	v := generateConstants([]byte("drlnhdsm"), 2*Width)
+	for i := 0; i < Width; i++ {
+		for j := 0; j < Width; j++ {
+			var sum fr.Element
+			sum.Add(&v[i], &v[Width+j])
+			mdsMatrix[i][j].Inverse(&sum)
+		}
+	}
+}
+
+// generateConstants derives field elements by chaining blake2s hashes of the personalization
+// and the previous digest, skipping the digests that are not canonical field elements
+func generateConstants(persona []byte, n int) []fr.Element {
+	constants := make([]fr.Element, 0, n)
+
+	var source []byte
+	for len(constants) < n {
+		h, _ := blake2s.New256(nil)
+		h.Write(persona)
+		h.Write(source)
+		source = h.Sum(nil)
+
+		var digest [32]byte
+		copy(digest[:], source)
+		if e, err := FromBytes(digest); err == nil {
+			constants = append(constants, e)
+		}
+	}
+
+	return constants
+}
+
+// Hash computes the Poseidon hash of up to Width field elements. Missing inputs are
+// padded with zeros. It panics if more than Width inputs are provided
+func Hash(inputs ...fr.Element) fr.Element {
+	if len(inputs) > Width {
+		panic("poseidon: too many inputs")
+	}
+
+	var state [Width]fr.Element
+	copy(state[:], inputs)
+
+	totalRounds := fullRounds + partialRounds
+	for round := 0; round < totalRounds; round++ {
+		full := round < fullRounds/2 || round >= fullRounds/2+partialRounds
+
+		for i := range state {
+			state[i].Add(&state[i], &roundConstants[round])
+		}
+
+		// quintic s-box, applied to the whole state in full rounds and to the first element in partial ones
+		for i := range state {
+			var b fr.Element
+			b.Square(&state[i])
+			b.Square(&b)
+			state[i].Mul(&state[i], &b)
+			if !full {
+				break
+			}
+		}
+
+		// the last round skips the linear layer
+		if round == totalRounds-1 {
+			break
+		}
+
+		var newState [Width]fr.Element
+		for i := 0; i < Width; i++ {
+			for j := 0; j < Width; j++ {
+				var tmp fr.Element
+				tmp.Mul(&state[j], &mdsMatrix[i][j])
+				newState[i].Add(&newState[i], &tmp)
+			}
+		}
+		state = newState
+	}
+
+	return state[0]
+}
+
+// HashBytes computes the Poseidon hash of up to Width field elements encoded with ToBytes
+func HashBytes(inputs ...[32]byte) ([32]byte, error) {
+	elements := make([]fr.Element, len(inputs))
+	for i, in := range inputs {
+		e, err := FromBytes(in)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		elements[i] = e
+	}
+
+	return ToBytes(Hash(elements...)), nil
+}
+
+// FromBytes decodes a field element serialized in little endian, which is the encoding
+// used by the rln lib. It fails if the value is not lower than the field modulus
+func FromBytes(b [32]byte) (fr.Element, error) {
+	be := reverse(b)
+
+	var e fr.Element
+	e.SetBytes(be[:])
+	if e.Bytes() != be {
+		return fr.Element{}, ErrNonCanonical
+	}
+
+	return e, nil
+}
+
+// ToBytes serializes a field element in little endian
+func ToBytes(e fr.Element) [32]byte {
+	return reverse(e.Bytes())
+}
+
+func reverse(b [32]byte) [32]byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package poseidon
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, s string) [32]byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+
+	var result [32]byte
+	copy(result[:], b)
+	return result
+}
+
+func TestIDCommitment(t *testing.T) {
+	// (identity key, identity commitment) pairs taken from rln.STATIC_GROUP_KEYS, which were generated by the rln lib
+	pairs := [][]string{
+		{"e9a4d05b1f539d65c59015a079ee89aabeafbcfc9734342d9559f81601e85417", "b74d3a5b3200ab1126fbee393496f33da497d4d9a7c56693f44d6155c0c34e13"},
+		{"d1ce3aea6cfb7be132d17e8d76fcbe4b7e34cef3979b4b905acfeff2f6d19724", "be47b76297791f535f4b56f973a19f07ec22d4eede2a41ff23c696089938bb21"},
+	}
+
+	for _, pair := range pairs {
+		commitment, err := HashBytes(decode(t, pair[0]))
+		require.NoError(t, err)
+		require.Equal(t, pair[1], hex.EncodeToString(commitment[:]))
+	}
+}
+
+func TestHashPadsInputs(t *testing.T) {
+	a := fr.NewElement(1)
+	var zero fr.Element
+
+	require.Equal(t, Hash(a), Hash(a, zero))
+	require.Equal(t, Hash(a), Hash(a, zero, zero))
+	require.NotEqual(t, Hash(a), Hash(zero, a))
+
+	require.Panics(t, func() { Hash(a, a, a, a) })
+}
+
+func TestBytesEncoding(t *testing.T) {
+	e := fr.NewElement(258)
+	b := ToBytes(e)
+	require.Equal(t, byte(2), b[0])
+	require.Equal(t, byte(1), b[1])
+
+	decoded, err := FromBytes(b)
+	require.NoError(t, err)
+	require.True(t, decoded.Equal(&e))
+
+	// the modulus and any larger value are rejected
+	var modulus [32]byte
+	fr.Modulus().FillBytes(modulus[:])
+	_, err = FromBytes(reverse(modulus))
+	require.ErrorIs(t, err, ErrNonCanonical)
+
+	var max [32]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	_, err = FromBytes(max)
+	require.ErrorIs(t, err, ErrNonCanonical)
+	_, err = HashBytes(max)
+	require.ErrorIs(t, err, ErrNonCanonical)
+}
+
+func BenchmarkHash(b *testing.B) {
+	x := fr.NewElement(1)
+	y := fr.NewElement(2)
+	for i := 0; i < b.N; i++ {
+		Hash(x, y)
+	}
+}
@@ -0,0 +1,263 @@
+package rln
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DEFAULT_PROVER_QUEUE_SIZE is the default number of proof requests a Prover queues before rejecting new ones
+const DEFAULT_PROVER_QUEUE_SIZE = 64
+
+// ProofResult is the outcome of an asynchronous proof generation
+type ProofResult struct {
+	Proof *RateLimitProof
+	Err   error
+}
+
+// ProverHooks are called by a Prover to report its metrics. They are called synchronously,
+// so they must not block nor call the Prover. Any of them can be nil
+type ProverHooks struct {
+	// QueueDepth is called with the number of queued requests whenever it changes
+	QueueDepth func(depth int)
+	// Latency is called once a proof is generated, with the time the request waited in the queue
+	// and the time spent generating the proof
+	Latency func(wait time.Duration, proving time.Duration)
+}
+
+// ProverConfig configures a Prover
+type ProverConfig struct {
+	// Workers is the number of proofs generated in parallel
+	Workers int
+	// QueueSize is the number of requests waiting for a worker before new requests are rejected with ErrQueueFull
+	QueueSize int
+	// Hooks report the metrics of the Prover
+	Hooks ProverHooks
+}
+
+// DefaultProverConfig returns a config with one worker per CPU and a queue of DEFAULT_PROVER_QUEUE_SIZE requests
+func DefaultProverConfig() ProverConfig {
+	return ProverConfig{
+		Workers:   runtime.NumCPU(),
+		QueueSize: DEFAULT_PROVER_QUEUE_SIZE,
+	}
+}
+
+type proofRequest struct {
+	ctx    context.Context
+	data   []byte
+	key    MembershipKeyPair
+	index  MembershipIndex
+	epoch  Epoch
+	queued time.Time
+	// started is closed when a worker takes the request
+	started chan struct{}
+	result  chan ProofResult
+}
+
+// deliver sends the result of the request, which is sent only once
+func (req *proofRequest) deliver(proof *RateLimitProof, err error) {
+	req.result <- ProofResult{Proof: proof, Err: err}
+	close(req.result)
+}
+
+// Prover generates proofs in the background with a bounded pool of workers, so that callers are
+// not blocked while the rln lib generates a proof. A proof that is being generated can not be
+// interrupted: cancelling its context only drops its result. A Prover is safe for concurrent use
+type Prover struct {
+	rln    *RLN
+	config ProverConfig
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*proofRequest
+	closed bool
+
+	workers sync.WaitGroup
+}
+
+// NewProver starts a Prover generating the proofs of `rln`. Zero values of the config fall back
+// to the values of DefaultProverConfig
+func NewProver(rln *RLN, config ProverConfig) *Prover {
+	defaults := DefaultProverConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+
+	p := &Prover{rln: rln, config: config}
+	p.cond = sync.NewCond(&p.mu)
+
+	p.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// GenerateProofAsync queues the generation of a proof, see RLN.GenerateProof. The returned channel
+// delivers exactly one result and is then closed. The result holds an error of kind ErrQueueFull
+// if the queue is full, ErrClosed if the Prover is closed, and the error of the context if it is
+// done before the proof is generated
+func (p *Prover) GenerateProofAsync(ctx context.Context, data []byte, key MembershipKeyPair, index MembershipIndex, epoch Epoch) <-chan ProofResult {
+	req := &proofRequest{
+		ctx:     ctx,
+		data:    data,
+		key:     key,
+		index:   index,
+		epoch:   epoch,
+		queued:  time.Now(),
+		started: make(chan struct{}),
+		result:  make(chan ProofResult, 1),
+	}
+
+	if err := ctx.Err(); err != nil {
+		req.deliver(nil, newError("GenerateProofAsync", err, nil))
+		return req.result
+	}
+
+	p.mu.Lock()
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		req.deliver(nil, newError("GenerateProofAsync", ErrClosed, nil))
+		return req.result
+	case len(p.queue) >= p.config.QueueSize:
+		p.mu.Unlock()
+		req.deliver(nil, newError("GenerateProofAsync", ErrQueueFull, nil))
+		return req.result
+	}
+
+	p.queue = append(p.queue, req)
+	p.queueDepthChanged()
+	p.cond.Signal()
+	p.mu.Unlock()
+
+	if ctx.Done() != nil {
+		// frees the slot of the request as soon as it is cancelled
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.cancel(req)
+			case <-req.started:
+			}
+		}()
+	}
+
+	return req.result
+}
+
+// QueueDepth returns the number of requests waiting for a worker
+func (p *Prover) QueueDepth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.queue)
+}
+
+// cancel removes a request from the queue, if no worker took it yet
+func (p *Prover) cancel(req *proofRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, queued := range p.queue {
+		if queued == req {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.queueDepthChanged()
+			req.deliver(nil, newError("GenerateProofAsync", req.ctx.Err(), nil))
+			return
+		}
+	}
+}
+
+// queueDepthChanged reports the depth of the queue. The caller must hold the lock
+func (p *Prover) queueDepthChanged() {
+	if p.config.Hooks.QueueDepth != nil {
+		p.config.Hooks.QueueDepth(len(p.queue))
+	}
+}
+
+// next waits for a request, it returns nil once the Prover is closed
+func (p *Prover) next() *proofRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.queue) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if len(p.queue) == 0 {
+		return nil
+	}
+
+	req := p.queue[0]
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	p.queueDepthChanged()
+	close(req.started)
+
+	return req
+}
+
+func (p *Prover) work() {
+	defer p.workers.Done()
+
+	for req := p.next(); req != nil; req = p.next() {
+		if err := req.ctx.Err(); err != nil {
+			req.deliver(nil, newError("GenerateProofAsync", err, nil))
+			continue
+		}
+
+		start := time.Now()
+		proof, err := p.rln.GenerateProof(req.data, req.key, req.index, req.epoch)
+		if p.config.Hooks.Latency != nil {
+			p.config.Hooks.Latency(start.Sub(req.queued), time.Since(start))
+		}
+
+		if ctxErr := req.ctx.Err(); ctxErr != nil {
+			// the caller is gone, the result is dropped
+			proof, err = nil, newError("GenerateProofAsync", ctxErr, nil)
+		}
+		req.deliver(proof, err)
+	}
+}
+
+// Close stops accepting requests, fails the queued requests with ErrClosed, and waits for the
+// proofs being generated
+func (p *Prover) Close() {
+	_ = p.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting requests and fails the queued requests with ErrClosed. It then waits
+// for the proofs being generated until the context is done, in which case it returns the error of
+// the context and the proofs are delivered once generated
+func (p *Prover) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, req := range p.queue {
+			close(req.started)
+			req.deliver(nil, newError("GenerateProofAsync", ErrClosed, nil))
+		}
+		p.queue = nil
+		p.queueDepthChanged()
+		p.cond.Broadcast()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package rln
+
+import (
+	"errors"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+// RecoverIDKey recovers the identity key of a member that published two messages in the same epoch.
+// The shares of a proof are points of the line y = a_0 + a_1 * x, where a_0 is the identity key
+// of the member and a_1 is fixed for each epoch, so two different points of the same epoch are
+// enough to interpolate a_0. See https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Linear-Equation-amp-SSS
+func RecoverIDKey(p1, p2 ProofMetadata) (IDKey, error) {
+	if p1.Nullifier != p2.Nullifier {
+		return IDKey{}, newError("RecoverIDKey", ErrInvalidShares, errors.New("the proofs have different nullifiers"))
+	}
+
+	x1, err := poseidon.FromBytes(p1.ShareX)
+	if err != nil {
+		return IDKey{}, newError("RecoverIDKey", ErrInvalidShares, err)
+	}
+	y1, err := poseidon.FromBytes(p1.ShareY)
+	if err != nil {
+		return IDKey{}, newError("RecoverIDKey", ErrInvalidShares, err)
+	}
+	x2, err := poseidon.FromBytes(p2.ShareX)
+	if err != nil {
+		return IDKey{}, newError("RecoverIDKey", ErrInvalidShares, err)
+	}
+	y2, err := poseidon.FromBytes(p2.ShareY)
+	if err != nil {
+		return IDKey{}, newError("RecoverIDKey", ErrInvalidShares, err)
+	}
+
+	if x1.Equal(&x2) {
+		return IDKey{}, newError("RecoverIDKey", ErrInvalidShares, errors.New("the shares belong to the same message"))
+	}
+
+	// a_1 = (y_1 - y_2) / (x_1 - x_2)
+	var dy, dx, a1 fr.Element
+	dy.Sub(&y1, &y2)
+	dx.Sub(&x1, &x2)
+	a1.Div(&dy, &dx)
+
+	// a_0 = y_1 - a_1 * x_1
+	var a0 fr.Element
+	a0.Mul(&a1, &x1)
+	a0.Sub(&y1, &a0)
+
+	return poseidon.ToBytes(a0), nil
+}
+
+// RecoverMembershipKeyPair recovers the identity key of a member that published two messages in the same epoch
+// (see RecoverIDKey) together with its identity commitment, which locates the member in the Merkle tree
+func RecoverMembershipKeyPair(p1, p2 ProofMetadata) (MembershipKeyPair, error) {
+	idKey, err := RecoverIDKey(p1, p2)
+	if err != nil {
+		return MembershipKeyPair{}, err
+	}
+
+	// the identity commitment is the poseidon hash of the identity key
+	idCommitment, err := poseidon.HashBytes(idKey)
+	if err != nil {
+		return MembershipKeyPair{}, newError("RecoverMembershipKeyPair", ErrInvalidShares, err)
+	}
+
+	return MembershipKeyPair{IDKey: idKey, IDCommitment: idCommitment}, nil
+}
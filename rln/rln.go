@@ -1,19 +1,30 @@
 // Package rln contains bindings for https://github.com/kilic/rln
 package rln
 
-/*
-#include "./librln.h"
-*/
-import "C"
 import (
 	"encoding/binary"
 	"errors"
-	"unsafe"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/waku-org/go-rln/rln/merkle"
+	"github.com/waku-org/go-rln/rln/poseidon"
 )
 
-// RLN represents the context used for rln.
+// RLN represents the context used for rln. It is safe for concurrent use: operations that
+// modify the Merkle tree are serialized, while proof generation, verification and root reads
+// can run in parallel.
 type RLN struct {
-	ptr *C.RLN_Bn256
+	// mu guards backend and its Merkle tree
+	mu      sync.RWMutex
+	backend Backend
+
+	depth int
+	// tree mirrors the Merkle tree held by the backend, which does not give access to its leaves and paths
+	tree *merkle.Tree
+	// roots holds the roots of the Merkle tree that proofs are accepted against
+	roots *RootHistory
 }
 
 // New returns a new RLN generated using the default merkle tree depth
@@ -22,49 +33,131 @@ func NewRLN(params []byte) (*RLN, error) {
 }
 
 // NewRLNWithDepth generates an instance of RLN. An instance supports both zkSNARKs logics
-// and Merkle tree data structure and operations. The parameter `depth`` indicates the depth of Merkle tree
+// and Merkle tree data structure and operations. The parameter `depth`` indicates the depth of Merkle tree.
+// If the parameters are known to be generated for another depth, see RegisterParamsDepth, an
+// error of kind ErrInvalidParams is returned, as the proofs of such an instance never verify.
+// Earlier versions accepted any depth, callers passing a depth other than MERKLE_TREE_DEPTH with
+// the bundled parameters must use NewRLN or NewRLNWithParams
 func NewRLNWithDepth(depth int, params []byte) (*RLN, error) {
-	r := &RLN{}
-
 	if len(params) == 0 {
-		return nil, errors.New("error in parameters.key")
+		return nil, newError("NewRLN", ErrInvalidParams, errors.New("error in parameters.key"))
 	}
 
-	buf := toBuffer(params)
+	p, err := newParams("NewRLN", params)
+	if err != nil {
+		return nil, err
+	}
 
-	size := int(unsafe.Sizeof(buf))
-	in := (*C.Buffer)(C.malloc(C.size_t(size)))
-	*in = buf
+	return newRLN(depth, p)
+}
 
-	if !bool(C.new_circuit_from_params(C.uintptr_t(depth), in, &r.ptr)) {
-		return nil, errors.New("failed to initialize")
+// NewRLNWithParams generates an instance of RLN for the depth the parameters were generated for,
+// or the default Merkle tree depth if it is not known
+func NewRLNWithParams(params *Params) (*RLN, error) {
+	depth, ok := params.Depth()
+	if !ok {
+		depth = MERKLE_TREE_DEPTH
+	}
+	return newRLN(depth, params)
+}
+
+func newRLN(depth int, params *Params) (*RLN, error) {
+	if depth <= 0 || depth >= 64 {
+		return nil, newError("NewRLN", ErrInvalidParams, fmt.Errorf("unsupported tree depth %d", depth))
+	}
+
+	if err := params.checkDepth(depth); err != nil {
+		return nil, newError("NewRLN", ErrInvalidParams, err)
+	}
+
+	backend, err := newNativeBackend(depth, params)
+	if err != nil {
+		return nil, newError("NewRLN", ErrInvalidParams, err)
 	}
 
+	return newRLNWithBackend("NewRLN", backend)
+}
+
+// NewRLNWithBackend generates an instance of RLN that relies on the given backend, which must
+// hold an empty Merkle tree. NewFakeBackend gives a backend for tests that does not need the rln lib
+func NewRLNWithBackend(backend Backend) (*RLN, error) {
+	if backend == nil {
+		return nil, newError("NewRLNWithBackend", ErrInvalidParams, errors.New("no backend"))
+	}
+
+	return newRLNWithBackend("NewRLNWithBackend", backend)
+}
+
+func newRLNWithBackend(op string, backend Backend) (*RLN, error) {
+	depth := backend.Depth()
+	tree, err := merkle.New(depth)
+	if err != nil {
+		return nil, newError(op, ErrInvalidParams, err)
+	}
+
+	r := &RLN{
+		backend: backend,
+		depth:   depth,
+		tree:    tree,
+		roots:   NewRootHistory(ACCEPTABLE_ROOT_WINDOW_SIZE),
+	}
+	r.roots.Add(tree.Root())
+
+	// closes the instances that are not explicitly closed, the circuit context leaks either way
+	runtime.SetFinalizer(r, (*RLN).Close)
+
 	return r, nil
 }
 
+// Close releases the RLN instance, waiting for in-flight operations to complete. Calling any
+// other method after Close returns ErrClosed (or false for the methods that report a bool).
+// Closing an instance more than once is a no-op.
+//
+// The circuit context of the rln lib leaks. This is blocked upstream: librln does not export a
+// destructor, so Close can not free it and the memory it holds, which includes the zkSNARK
+// parameters, is only reclaimed when the process exits. Close only guarantees that the context is
+// never used again. Long running processes should create a single instance and share it rather
+// than creating one per use
+func (r *RLN) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backend == nil {
+		return nil
+	}
+
+	r.backend = nil
+	runtime.SetFinalizer(r, nil)
+
+	return nil
+}
+
 // MembershipKeyGen generates a MembershipKeyPair that can be used for the registration into the rln membership contract
 func (r *RLN) MembershipKeyGen() (*MembershipKeyPair, error) {
-	buffer := toBuffer([]byte{})
-	if !bool(C.key_gen(r.ptr, &buffer)) {
-		return nil, errors.New("error in key generation")
-	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	key := &MembershipKeyPair{
-		IDKey:        [32]byte{},
-		IDCommitment: [32]byte{},
+	if r.backend == nil {
+		return nil, newError("MembershipKeyGen", ErrClosed, nil)
 	}
 
-	// the public and secret keys together are 64 bytes
-	generatedKeys := C.GoBytes(unsafe.Pointer(buffer.ptr), C.int(buffer.len))
-	if len(generatedKeys) != 64 {
-		return nil, errors.New("the generated keys are invalid")
+	key, err := r.backend.KeyGen()
+	if err != nil {
+		return nil, newError("MembershipKeyGen", ErrFFI, err)
 	}
 
-	copy(key.IDKey[:], generatedKeys[:32])
-	copy(key.IDCommitment[:], generatedKeys[32:64])
+	return &key, nil
+}
+
+// CommitmentFor computes the identity commitment of an identity key, which is its Poseidon hash.
+// It is computed in Go and does not need an RLN instance
+func CommitmentFor(idKey IDKey) (IDCommitment, error) {
+	idCommitment, err := poseidon.HashBytes(idKey)
+	if err != nil {
+		return IDCommitment{}, newError("CommitmentFor", ErrInvalidKey, err)
+	}
 
-	return key, nil
+	return idCommitment, nil
 }
 
 // appendLength returns length prefixed version of the input with the following format
@@ -75,46 +168,21 @@ func appendLength(input []byte) []byte {
 	return append(inputLen, input...)
 }
 
-// toBuffer converts the input to a buffer object that is used to communicate data with the rln lib
-func toBuffer(data []byte) C.Buffer {
-	dataPtr, dataLen := sliceToPtr(data)
-	return C.Buffer{
-		ptr: dataPtr,
-		len: C.uintptr_t(dataLen),
-	}
-}
-
-func sliceToPtr(slice []byte) (*C.uchar, C.int) {
-	if len(slice) == 0 {
-		return nil, 0
-	} else {
-		return (*C.uchar)(unsafe.Pointer(&slice[0])), C.int(len(slice))
-	}
-}
-
 // Hash hashes the plain text supplied in inputs_buffer and then maps it to a field element
 // this proc is used to map arbitrary signals to field element for the sake of proof generation
 // inputs holds the hash input as a byte slice, the output slice will contain a 32 byte slice
 func (r *RLN) Hash(data []byte) (MerkleNode, error) {
-	//  a thin layer on top of the Nim wrapper of the Poseidon hasher
-	lenPrefData := appendLength(data)
-
-	hashInputBuffer := toBuffer(lenPrefData)
-	size := int(unsafe.Sizeof(hashInputBuffer))
-	in := (*C.Buffer)(C.malloc(C.size_t(size)))
-	*in = hashInputBuffer
-
-	var output []byte
-	out := toBuffer(output)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	if !bool(C.signal_to_field(r.ptr, in, &out)) {
-		return MerkleNode{}, errors.New("failed to hash")
+	if r.backend == nil {
+		return MerkleNode{}, newError("Hash", ErrClosed, nil)
 	}
 
-	b := C.GoBytes(unsafe.Pointer(out.ptr), C.int(out.len))
-
-	var result MerkleNode
-	copy(result[:], b)
+	result, err := r.backend.Hash(data)
+	if err != nil {
+		return MerkleNode{}, newError("Hash", ErrFFI, err)
+	}
 
 	return result, nil
 }
@@ -123,123 +191,454 @@ func (r *RLN) Hash(data []byte) (MerkleNode, error) {
 // The output will containt the proof data and should be parsed as |proof<256>|root<32>|epoch<32>|share_x<32>|share_y<32>|nullifier<32>|
 // integers wrapped in <> indicate value sizes in bytes
 func (r *RLN) GenerateProof(data []byte, key MembershipKeyPair, index MembershipIndex, epoch Epoch) (*RateLimitProof, error) {
-	input := serialize(key.IDKey, index, epoch, data)
-	inputBuf := toBuffer(input)
-	size := int(unsafe.Sizeof(inputBuf))
-	in := (*C.Buffer)(C.malloc(C.size_t(size)))
-	*in = inputBuf
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	var output []byte
-	out := toBuffer(output)
+	if r.backend == nil {
+		return nil, newError("GenerateProof", ErrClosed, nil)
+	}
 
-	if !bool(C.generate_proof(r.ptr, in, &out)) {
-		return nil, errors.New("could not generate the proof")
+	if !r.validIndex(index) {
+		return nil, newError("GenerateProof", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
 	}
 
-	proofBytes := C.GoBytes(unsafe.Pointer(out.ptr), C.int(out.len))
+	if err := checkField("GenerateProof", ErrInvalidKey, "the identity key", key.IDKey); err != nil {
+		return nil, err
+	}
+	if err := checkField("GenerateProof", ErrInvalidField, "the epoch", [32]byte(epoch)); err != nil {
+		return nil, err
+	}
 
-	if len(proofBytes) != 416 {
-		return nil, errors.New("invalid proof generated")
+	proof, err := r.backend.Prove(data, key.IDKey, index, epoch)
+	if err != nil {
+		return nil, newError("GenerateProof", ErrFFI, err)
 	}
 
-	// parse the proof as |zkSNARKs<256>|root<32>|epoch<32>|share_x<32>|share_y<32>|nullifier<32>|
+	return &proof, nil
+}
 
-	proofOffset := 256
-	rootOffset := proofOffset + 32
-	epochOffset := rootOffset + 32
-	shareXOffset := epochOffset + 32
-	shareYOffset := shareXOffset + 32
-	nullifierOffset := shareYOffset + 32
+// Verify verifies a proof generated for the RLN.
+// proof [ proof<256>| root<32>| epoch<32>| share_x<32>| share_y<32>| nullifier<32> | signal_len<8> | signal<var> ]
+// It returns false both when the proof is invalid and when the verification could not run,
+// use VerifyProof to tell both cases apart
+func (r *RLN) Verify(data []byte, proof RateLimitProof) bool {
+	verified, err := r.VerifyProof(data, proof)
+	return err == nil && verified
+}
 
-	var zkproof ZKSNARK
-	var proofRoot, shareX, shareY MerkleNode
-	var epochR Epoch
-	var nullifier Nullifier
+// VerifyProof verifies a proof generated for the RLN. It returns false and no error if the
+// proof is invalid, and an error if the verification could not be performed. A proof whose
+// public inputs are not field elements is rejected with an error of kind ErrInvalidProof
+func (r *RLN) VerifyProof(data []byte, proof RateLimitProof) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	copy(zkproof[:], proofBytes[0:proofOffset])
-	copy(proofRoot[:], proofBytes[proofOffset:rootOffset])
-	copy(epochR[:], proofBytes[rootOffset:epochOffset])
-	copy(shareX[:], proofBytes[epochOffset:shareXOffset])
-	copy(shareY[:], proofBytes[shareXOffset:shareYOffset])
-	copy(nullifier[:], proofBytes[shareYOffset:nullifierOffset])
+	if r.backend == nil {
+		return false, newError("VerifyProof", ErrClosed, nil)
+	}
 
-	return &RateLimitProof{
-		Proof:      zkproof,
-		MerkleRoot: proofRoot,
-		Epoch:      epochR,
-		ShareX:     shareX,
-		ShareY:     shareY,
-		Nullifier:  nullifier,
-	}, nil
+	return r.verify("VerifyProof", data, proof)
 }
 
-// Verify verifies a proof generated for the RLN.
-// proof [ proof<256>| root<32>| epoch<32>| share_x<32>| share_y<32>| nullifier<32> | signal_len<8> | signal<var> ]
-func (r *RLN) Verify(data []byte, proof RateLimitProof) bool {
-	proofBytes := proof.serialize(data)
-	proofBuf := toBuffer(proofBytes)
-	size := int(unsafe.Sizeof(proofBuf))
-	in := (*C.Buffer)(C.malloc(C.size_t(size)))
-	*in = proofBuf
+// verify checks the public inputs of a proof and verifies it. The caller must hold the lock and
+// check that the instance is not closed
+func (r *RLN) verify(op string, data []byte, proof RateLimitProof) (bool, error) {
+	if err := checkProof(op, proof); err != nil {
+		return false, err
+	}
 
-	result := uint32(0)
-	res := C.uint(result)
-	if !bool(C.verify(r.ptr, in, &res)) {
-		return false
+	verified, err := r.backend.Verify(data, proof)
+	if err != nil {
+		return false, newError(op, ErrFFI, err)
 	}
 
-	return uint32(res) == 0
+	return verified, nil
+}
+
+// VerifyWithRoots verifies a proof generated for the RLN, and checks that it was generated against one of the
+// given Merkle tree roots. A proof against any other root is rejected with an error of kind ErrUnknownRoot,
+// without running the verification
+func (r *RLN) VerifyWithRoots(data []byte, proof RateLimitProof, roots []MerkleNode) (bool, error) {
+	for _, root := range roots {
+		if root == proof.MerkleRoot {
+			return r.VerifyProof(data, proof)
+		}
+	}
+
+	return false, newError("VerifyWithRoots", ErrUnknownRoot, nil)
+}
+
+// ValidateProof verifies a proof generated for the RLN against one of the recent roots of the Merkle tree,
+// see SetRootWindowSize. A proof against any other root is rejected with an error of kind ErrUnknownRoot
+func (r *RLN) ValidateProof(data []byte, proof RateLimitProof) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.backend == nil {
+		return false, newError("ValidateProof", ErrClosed, nil)
+	}
+
+	if !r.roots.Contains(proof.MerkleRoot) {
+		return false, newError("ValidateProof", ErrUnknownRoot, nil)
+	}
+
+	return r.verify("ValidateProof", data, proof)
+}
+
+// ValidRoots returns the roots accepted by ValidateProof, from the oldest to the latest
+func (r *RLN) ValidRoots() []MerkleNode {
+	return r.roots.Roots()
+}
+
+// SetRootWindowSize sets the number of recent Merkle tree roots accepted by ValidateProof,
+// which is ACCEPTABLE_ROOT_WINDOW_SIZE by default
+func (r *RLN) SetRootWindowSize(size int) {
+	r.roots.SetSize(size)
 }
 
 // InsertMember adds the member to the tree
 func (r *RLN) InsertMember(idComm IDCommitment) bool {
-	buf := toBuffer(idComm[:])
+	_, err := r.Insert(idComm)
+	return err == nil
+}
+
+// Insert adds the member to the next empty leaf of the tree and returns its index
+func (r *RLN) Insert(idComm IDCommitment) (MembershipIndex, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.insert("Insert", idComm)
+	if err != nil {
+		return 0, err
+	}
+
+	r.roots.Add(r.tree.Root())
+
+	return index, nil
+}
+
+// insert adds the member to the tree. The caller must hold the write lock
+func (r *RLN) insert(op string, idComm IDCommitment) (MembershipIndex, error) {
+	if r.backend == nil {
+		return 0, newError(op, ErrClosed, nil)
+	}
+
+	if !r.validIndex(r.tree.NextIndex()) {
+		return 0, newError(op, ErrTreeFull, nil)
+	}
+
+	// the commitment is checked before the rln lib is called, so that the lib and the mirror can
+	// not disagree on a value that only one of them accepts
+	if err := checkField(op, ErrInvalidCommitment, "the identity commitment", idComm); err != nil {
+		return 0, err
+	}
+
+	if err := r.backend.AppendLeaf(idComm); err != nil {
+		return 0, newError(op, ErrFFI, err)
+	}
 
-	size := int(unsafe.Sizeof(buf))
-	in := (*C.Buffer)(C.malloc(C.size_t(size)))
-	*in = buf
+	index, err := r.tree.Insert(idComm)
+	if err != nil {
+		return 0, newError(op, ErrFFI, err)
+	}
 
-	res := C.update_next_member(r.ptr, in)
-	return bool(res)
+	return index, nil
 }
 
 // DeleteMember removes an IDCommitment key from the tree. The index
 // parameter is the position of the id commitment key to be deleted from the tree.
 // The deleted id commitment key is replaced with a zero leaf
 func (r *RLN) DeleteMember(index MembershipIndex) bool {
-	deletionSuccess := bool(C.delete_member(r.ptr, C.uintptr_t(index)))
-	return deletionSuccess
+	return r.Delete(index) == nil
 }
 
-// GetMerkleRoot reads the Merkle Tree root after insertion
-func (r *RLN) GetMerkleRoot() (MerkleNode, error) {
-	var output []byte
-	out := toBuffer(output)
+// Delete replaces the leaf at `index` with a zero leaf
+func (r *RLN) Delete(index MembershipIndex) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backend == nil {
+		return newError("Delete", ErrClosed, nil)
+	}
+
+	if !r.validIndex(index) {
+		return newError("Delete", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
+	}
 
-	if !bool(C.get_root(r.ptr, &out)) {
-		return MerkleNode{}, errors.New("could not get the root")
+	if err := r.backend.DeleteLeaf(index); err != nil {
+		return newError("Delete", ErrFFI, err)
 	}
 
-	b := C.GoBytes(unsafe.Pointer(out.ptr), C.int(out.len))
+	if err := r.tree.Delete(index); err != nil {
+		return newError("Delete", ErrFFI, err)
+	}
+
+	r.roots.Add(r.tree.Root())
+
+	return nil
+}
+
+// NextIndex returns the index of the leaf used by the next insertion
+func (r *RLN) NextIndex() MembershipIndex {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.tree.NextIndex()
+}
+
+// IndexOf returns the index of the member with the given identity commitment
+func (r *RLN) IndexOf(idComm IDCommitment) (MembershipIndex, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.backend == nil {
+		return 0, false
+	}
+
+	return r.tree.IndexOf(idComm)
+}
+
+// MerklePath returns the authentication path of the member at `index`, which can be checked
+// against the Merkle tree root with merkle.VerifyPath
+func (r *RLN) MerklePath(index MembershipIndex) (merkle.Path, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.backend == nil {
+		return merkle.Path{}, newError("MerklePath", ErrClosed, nil)
+	}
+
+	if !r.validIndex(index) {
+		return merkle.Path{}, newError("MerklePath", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
+	}
+
+	return r.tree.Path(index)
+}
+
+// validIndex checks whether the index is a leaf of the Merkle tree
+func (r *RLN) validIndex(index MembershipIndex) bool {
+	return uint64(index) < uint64(1)<<uint(r.depth)
+}
+
+// GetMerkleRoot reads the Merkle Tree root after insertion
+func (r *RLN) GetMerkleRoot() (MerkleNode, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	if len(b) != 32 {
-		return MerkleNode{}, errors.New("wrong output size")
+	if r.backend == nil {
+		return MerkleNode{}, newError("GetMerkleRoot", ErrClosed, nil)
 	}
 
-	var result MerkleNode
-	copy(result[:], b)
+	result, err := r.backend.Root()
+	if err != nil {
+		return MerkleNode{}, newError("GetMerkleRoot", ErrFFI, err)
+	}
 
 	return result, nil
 }
 
-// AddAll adds members to the Merkle tree
+// AddAll adds members to the Merkle tree. No other tree operation is interleaved
+// with the insertion of the list
 func (r *RLN) AddAll(list []IDCommitment) bool {
-	for _, member := range list {
-		if !r.InsertMember(member) {
-			return false
+	return r.InsertAll(list) == nil
+}
+
+// InsertAll adds members to the Merkle tree. No other tree operation is interleaved
+// with the insertion of the list. If a member can not be inserted, the members that
+// precede it remain in the tree and the returned error reports its position in the list
+func (r *RLN) InsertAll(list []IDCommitment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the intermediate roots are never observed by other members, only the final one is recorded
+	defer func() { r.roots.Add(r.tree.Root()) }()
+
+	for i, member := range list {
+		if _, err := r.insert("InsertAll", member); err != nil {
+			return newError("InsertAll", err.(*Error).Kind, &PositionError{Position: i})
 		}
 	}
-	return true
+	return nil
+}
+
+// InsertMembers sets the leaves of the tree from `start` onwards to the members of the list. `start`
+// can not be lower than NextIndex, the leaves between NextIndex and `start` are left empty, and there
+// can be at most MAX_MEMBERS_GAP of them. The members are appended one by one, as InsertAll does, it
+// is not faster than InsertAll. If a member can not be inserted, the members that precede it remain in
+// the tree and the returned error holds a PositionError with its position in the list
+func (r *RLN) InsertMembers(start MembershipIndex, list []IDCommitment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backend == nil {
+		return newError("InsertMembers", ErrClosed, nil)
+	}
+
+	next := r.tree.NextIndex()
+	if start < next {
+		return newError("InsertMembers", ErrInvalidIndex, fmt.Errorf("index %d is already used, the next index is %d", start, next))
+	}
+
+	if uint64(start) > r.tree.Capacity() {
+		return newError("InsertMembers", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", start))
+	}
+
+	if err := r.checkGap("InsertMembers", start); err != nil {
+		return err
+	}
+
+	// the members are checked before modifying the tree, only the ones preceding
+	// the first invalid member are inserted
+	count := len(list)
+	var kind error
+	for i, member := range list {
+		if !r.validIndex(start + MembershipIndex(i)) {
+			count, kind = i, ErrTreeFull
+			break
+		}
+		if _, err := poseidon.FromBytes(member); err != nil {
+			count, kind = i, ErrInvalidCommitment
+			break
+		}
+	}
+
+	if count == 0 && kind != nil {
+		return newError("InsertMembers", kind, &PositionError{Position: 0})
+	}
+
+	defer func() { r.roots.Add(r.tree.Root()) }()
+
+	if err := r.appendMembers(start, list[:count]); err != nil {
+		return newError("InsertMembers", ErrFFI, err)
+	}
+
+	if kind != nil {
+		return newError("InsertMembers", kind, &PositionError{Position: count})
+	}
+
+	return nil
+}
+
+// appendMembers sets the leaves from `start` onwards to the members of the list, which must be
+// field elements, and the leaves between NextIndex and `start` to zero leaves. Each leaf is appended
+// to the backend with its own call. The caller must hold the write lock and check the gap
+func (r *RLN) appendMembers(start MembershipIndex, list []IDCommitment) error {
+	next := r.tree.NextIndex()
+	gap := int(start - next)
+	leaves := append(make([]IDCommitment, gap), list...)
+
+	for i, leaf := range leaves {
+		if err := r.backend.AppendLeaf(leaf); err != nil {
+			// the mirror keeps the leaves accepted by the backend
+			if err := r.tree.SetLeaves(next, leaves[:i]); err != nil {
+				return fmt.Errorf("the mirror could not keep the leaves accepted by the backend: %w", err)
+			}
+			if i >= gap {
+				return fmt.Errorf("%w: %v", &PositionError{Position: i - gap}, err)
+			}
+			return err
+		}
+	}
+
+	return r.tree.SetLeaves(next, leaves)
+}
+
+// checkGap returns an error of kind ErrInvalidIndex if a member at `index` leaves more than
+// MAX_MEMBERS_GAP empty leaves after NextIndex. The caller must hold the lock
+func (r *RLN) checkGap(op string, index MembershipIndex) error {
+	if next := r.tree.NextIndex(); index > next && index-next > MAX_MEMBERS_GAP {
+		return newError(op, ErrInvalidIndex, fmt.Errorf("index %d is %d leaves after the next index, at most %d empty leaves can be left", index, index-next, MAX_MEMBERS_GAP))
+	}
+	return nil
+}
+
+// SetMember sets the leaf at `index`. The rln lib can only append leaves and replace a leaf with a
+// zero leaf, so either `index` is not lower than NextIndex, and the leaves between NextIndex and
+// `index` are left empty, or idComm is zero and the member at `index` is deleted. At most
+// MAX_MEMBERS_GAP empty leaves can be left. Setting a leaf to the value it holds does nothing, and
+// any other change of a leaf below NextIndex, including filling a leaf that was left empty, is
+// rejected with an error of kind ErrUnsupported. Members received out of order, such as the events
+// of a membership contract, must be set in index order
+func (r *RLN) SetMember(index MembershipIndex, idComm IDCommitment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backend == nil {
+		return newError("SetMember", ErrClosed, nil)
+	}
+
+	if !r.validIndex(index) {
+		return newError("SetMember", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
+	}
+
+	if _, err := poseidon.FromBytes(idComm); err != nil {
+		return newError("SetMember", ErrInvalidCommitment, err)
+	}
+
+	if err := r.checkGap("SetMember", index); err != nil {
+		return err
+	}
+	if err := r.checkReplace("SetMember", index, idComm); err != nil {
+		return err
+	}
+
+	current, err := r.tree.Leaf(index)
+	if err != nil {
+		return newError("SetMember", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
+	}
+
+	var zero IDCommitment
+	switch {
+	case index >= r.tree.NextIndex():
+		if err := r.appendMembers(index, []IDCommitment{idComm}); err != nil {
+			return newError("SetMember", ErrFFI, err)
+		}
+	case current == idComm:
+		// the leaf already holds the member
+		return nil
+	case idComm == zero:
+		if err := r.backend.DeleteLeaf(index); err != nil {
+			return newError("SetMember", ErrFFI, err)
+		}
+		if err := r.tree.Delete(index); err != nil {
+			return newError("SetMember", ErrFFI, err)
+		}
+	}
+
+	r.roots.Add(r.tree.Root())
+
+	return nil
+}
+
+// checkReplace returns an error of kind ErrUnsupported if setting the leaf at `index` replaces a
+// leaf below NextIndex by another member, which the rln lib can not do. The caller must hold the lock
+func (r *RLN) checkReplace(op string, index MembershipIndex, idComm IDCommitment) error {
+	if index >= r.tree.NextIndex() || idComm == (IDCommitment{}) {
+		return nil
+	}
+
+	if leaf, err := r.tree.Leaf(index); err == nil && leaf == idComm {
+		return nil
+	}
+
+	return newError(op, ErrUnsupported, fmt.Errorf("the backend can not replace the leaf at index %d", index))
+}
+
+// GetMember returns the identity commitment at `index`, which is zero for an empty leaf
+func (r *RLN) GetMember(index MembershipIndex) (IDCommitment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.backend == nil {
+		return IDCommitment{}, newError("GetMember", ErrClosed, nil)
+	}
+
+	leaf, err := r.tree.Leaf(index)
+	if err != nil {
+		return IDCommitment{}, newError("GetMember", ErrInvalidIndex, fmt.Errorf("index %d is out of bounds", index))
+	}
+
+	return leaf, nil
 }
 
 // CalcMerkleRoot returns the root of the Merkle tree that is computed from the supplied list
@@ -248,12 +647,11 @@ func CalcMerkleRoot(list []IDCommitment, params []byte) (MerkleNode, error) {
 	if err != nil {
 		return MerkleNode{}, err
 	}
+	defer rln.Close()
 
 	// create a Merkle tree
-	for _, c := range list {
-		if !rln.InsertMember(c) {
-			return MerkleNode{}, errors.New("could not add member")
-		}
+	if err := rln.InsertAll(list); err != nil {
+		return MerkleNode{}, err
 	}
 
 	return rln.GetMerkleRoot()
@@ -268,6 +666,7 @@ func CreateMembershipList(n int, params []byte) ([]MembershipKeyPair, MerkleNode
 	if err != nil {
 		return nil, MerkleNode{}, err
 	}
+	defer rln.Close()
 
 	var output []MembershipKeyPair
 	for i := 0; i < n; i++ {
@@ -280,8 +679,8 @@ func CreateMembershipList(n int, params []byte) ([]MembershipKeyPair, MerkleNode
 		output = append(output, *keypair)
 
 		// insert the key to the Merkle tree
-		if !rln.InsertMember(keypair.IDCommitment) {
-			return nil, MerkleNode{}, errors.New("could not insert member")
+		if _, err := rln.Insert(keypair.IDCommitment); err != nil {
+			return nil, MerkleNode{}, err
 		}
 	}
 
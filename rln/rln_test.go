@@ -2,12 +2,26 @@ package rln
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/stretchr/testify/suite"
+	"github.com/waku-org/go-rln/rln/merkle"
+	"github.com/waku-org/go-rln/rln/poseidon"
 )
 
 func TestRLNSuite(t *testing.T) {
@@ -39,7 +53,7 @@ func (s *RLNSuite) SetupTest() {
 }
 
 func (s *RLNSuite) TestMembershipKeyGen() {
-	rln, err := NewRLNWithDepth(32, s.parameters)
+	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
 
 	key, err := rln.MembershipKeyGen()
@@ -53,7 +67,7 @@ func (s *RLNSuite) TestMembershipKeyGen() {
 }
 
 func (s *RLNSuite) TestGetMerkleRoot() {
-	rln, err := NewRLNWithDepth(32, s.parameters)
+	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
 
 	root1, err := rln.GetMerkleRoot()
@@ -68,7 +82,7 @@ func (s *RLNSuite) TestGetMerkleRoot() {
 }
 
 func (s *RLNSuite) TestInsertMember() {
-	rln, err := NewRLNWithDepth(32, s.parameters)
+	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
 
 	keypair, err := rln.MembershipKeyGen()
@@ -79,7 +93,7 @@ func (s *RLNSuite) TestInsertMember() {
 }
 
 func (s *RLNSuite) TestRemoveMember() {
-	rln, err := NewRLNWithDepth(32, s.parameters)
+	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
 
 	deleted := rln.DeleteMember(MembershipIndex(0))
@@ -87,7 +101,7 @@ func (s *RLNSuite) TestRemoveMember() {
 }
 
 func (s *RLNSuite) TestMerkleTreeConsistenceBetweenDeletionAndInsertion() {
-	rln, err := NewRLNWithDepth(32, s.parameters)
+	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
 
 	root1, err := rln.GetMerkleRoot()
@@ -123,8 +137,407 @@ func (s *RLNSuite) TestMerkleTreeConsistenceBetweenDeletionAndInsertion() {
 	s.Equal(root1, root3)
 }
 
+func (s *RLNSuite) TestInsertMembers() {
+	_, commitments := staticGroupCommitments(s)
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	s.NoError(rln.InsertMembers(0, commitments[:40]))
+	s.NoError(rln.InsertMembers(40, commitments[40:]))
+	s.Equal(MembershipIndex(STATIC_GROUP_SIZE), rln.NextIndex())
+
+	root, err := rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+	s.Equal(root, MerkleNode(rln.tree.Root()))
+
+	// the indexes that are used can not be filled again
+	err = rln.InsertMembers(10, commitments[:1])
+	s.ErrorIs(err, ErrInvalidIndex)
+
+	// the members are inserted after the gap
+	s.NoError(rln.InsertMembers(STATIC_GROUP_SIZE+5, commitments[:2]))
+	s.Equal(MembershipIndex(STATIC_GROUP_SIZE+7), rln.NextIndex())
+	index, ok := rln.IndexOf(commitments[1])
+	s.True(ok)
+	s.Equal(MembershipIndex(1), index)
+
+	root, err = rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(root, MerkleNode(rln.tree.Root()))
+
+	// the members preceding an invalid commitment are inserted
+	invalid := IDCommitment{}
+	for i := range invalid {
+		invalid[i] = 0xff
+	}
+	err = rln.InsertMembers(rln.NextIndex(), []IDCommitment{commitments[2], commitments[3], invalid, commitments[4]})
+	s.ErrorIs(err, ErrInvalidCommitment)
+	var position *PositionError
+	s.ErrorAs(err, &position)
+	s.Equal(2, position.Position)
+	s.Equal(MembershipIndex(STATIC_GROUP_SIZE+9), rln.NextIndex())
+
+	root, err = rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(root, MerkleNode(rln.tree.Root()))
+	s.True(rln.roots.Contains(root))
+
+	err = rln.InsertMembers(MembershipIndex(1)<<MERKLE_TREE_DEPTH+1, nil)
+	s.ErrorIs(err, ErrInvalidIndex)
+
+	// the number of empty leaves left before the members is bounded
+	next := rln.NextIndex()
+	s.ErrorIs(rln.InsertMembers(next+MAX_MEMBERS_GAP+1, commitments[:1]), ErrInvalidIndex)
+	s.ErrorIs(rln.SetMember(next+MAX_MEMBERS_GAP+1, commitments[0]), ErrInvalidIndex)
+	s.Equal(next, rln.NextIndex())
+
+	// the mirror keeps the leaves the backend accepted before failing
+	fake, err := NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
+	limited, err := NewRLNWithBackend(&limitedBackend{Backend: fake, leaves: 5})
+	s.NoError(err)
+	defer limited.Close()
+
+	err = limited.InsertMembers(2, commitments[:10])
+	s.ErrorIs(err, ErrFFI)
+	s.ErrorAs(err, &position)
+	s.Equal(3, position.Position)
+	s.Equal(MembershipIndex(5), limited.NextIndex())
+
+	root, err = limited.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(root, MerkleNode(limited.tree.Root()))
+	s.True(limited.roots.Contains(root))
+}
+
+// limitedBackend accepts a limited number of leaves
+type limitedBackend struct {
+	Backend
+	leaves int
+}
+
+func (b *limitedBackend) AppendLeaf(idComm IDCommitment) error {
+	if b.leaves == 0 {
+		return errors.New("the tree is full")
+	}
+	b.leaves--
+	return b.Backend.AppendLeaf(idComm)
+}
+
+func (s *RLNSuite) TestSetMember() {
+	groupKeyPairs, commitments := staticGroupCommitments(s)
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	// members set in index order leave zero leaves in the gaps
+	s.NoError(rln.SetMember(3, commitments[0]))
+	s.NoError(rln.SetMember(10, commitments[1]))
+	s.NoError(rln.SetMember(11, commitments[2]))
+	s.Equal(MembershipIndex(12), rln.NextIndex())
+
+	appended, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer appended.Close()
+	list := make([]IDCommitment, 12)
+	list[3], list[10], list[11] = commitments[0], commitments[1], commitments[2]
+	s.NoError(appended.InsertAll(list))
+
+	sameRoot := func() {
+		root, err := rln.GetMerkleRoot()
+		s.NoError(err)
+		expected, err := appended.GetMerkleRoot()
+		s.NoError(err)
+		s.Equal(expected, root)
+	}
+	sameRoot()
+
+	for i, c := range list {
+		member, err := rln.GetMember(MembershipIndex(i))
+		s.NoError(err)
+		s.Equal(c, member)
+	}
+	member, err := rln.GetMember(12)
+	s.NoError(err)
+	s.Equal(IDCommitment{}, member)
+
+	// setting a leaf to the value it holds does nothing, and setting a zero leaf deletes the member
+	s.NoError(rln.SetMember(10, commitments[1]))
+	s.NoError(rln.SetMember(10, IDCommitment{}))
+	s.NoError(appended.Delete(10))
+	sameRoot()
+
+	// the rln lib can not write the other leaves below NextIndex, the empty ones included
+	s.ErrorIs(rln.SetMember(3, commitments[5]), ErrUnsupported)
+	s.ErrorIs(rln.SetMember(5, commitments[5]), ErrUnsupported)
+	s.ErrorIs(rln.SetMember(10, commitments[1]), ErrUnsupported)
+	s.Equal(MembershipIndex(12), rln.NextIndex())
+	sameRoot()
+
+	// a member set after a gap can generate proofs
+	proof, err := rln.GenerateProof([]byte("Hello"), groupKeyPairs[0], MembershipIndex(3), ToEpoch(1))
+	s.NoError(err)
+	verified, err := rln.ValidateProof([]byte("Hello"), *proof)
+	s.NoError(err)
+	s.True(verified)
+
+	invalid := IDCommitment{}
+	for i := range invalid {
+		invalid[i] = 0xff
+	}
+	s.ErrorIs(rln.SetMember(20, invalid), ErrInvalidCommitment)
+	s.ErrorIs(rln.SetMember(MembershipIndex(1)<<MERKLE_TREE_DEPTH, commitments[0]), ErrInvalidIndex)
+	_, err = rln.GetMember(MembershipIndex(1) << MERKLE_TREE_DEPTH)
+	s.ErrorIs(err, ErrInvalidIndex)
+}
+
+func (s *RLNSuite) TestTreeSnapshot() {
+	_, commitments := staticGroupCommitments(s)
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	s.NoError(rln.InsertAll(commitments[:20]))
+	s.NoError(rln.Delete(3))
+	s.NoError(rln.Delete(11))
+	s.NoError(rln.SetMember(30, commitments[30]))
+
+	root, err := rln.GetMerkleRoot()
+	s.NoError(err)
+
+	var snapshot bytes.Buffer
+	s.NoError(rln.SaveTree(&snapshot))
+	data := snapshot.Bytes()
+
+	params, err := ParseParams(bytes.NewReader(s.parameters))
+	s.NoError(err)
+	restored, err := NewRLNFromSnapshot(params, bytes.NewReader(data))
+	s.NoError(err)
+	defer restored.Close()
+
+	restoredRoot, err := restored.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(root, restoredRoot)
+	s.Equal(rln.NextIndex(), restored.NextIndex())
+	s.Equal([]MerkleNode{root}, restored.ValidRoots())
+	for i := MembershipIndex(0); i < rln.NextIndex(); i++ {
+		expected, err := rln.GetMember(i)
+		s.NoError(err)
+		member, err := restored.GetMember(i)
+		s.NoError(err)
+		s.Equal(expected, member)
+	}
+
+	// the restored tree generates valid proofs
+	keys, err := toMembershipKeyPairs(STATIC_GROUP_KEYS)
+	s.NoError(err)
+	proof, err := restored.GenerateProof([]byte("Hello"), keys[30], 30, ToEpoch(1))
+	s.NoError(err)
+	s.Equal(root, proof.MerkleRoot)
+	s.True(restored.Verify([]byte("Hello"), *proof))
+
+	// a snapshot is only loaded into an empty tree
+	other, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer other.Close()
+	s.NoError(other.InsertAll(commitments[50:60]))
+	otherRoot, err := other.GetMerkleRoot()
+	s.NoError(err)
+	s.ErrorIs(other.LoadTree(bytes.NewReader(data)), ErrUnsupported)
+
+	// any corruption is detected
+	for i := range data {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0x01
+		err := other.LoadTree(bytes.NewReader(corrupted))
+		if i >= 4 && i < 8 {
+			s.ErrorIs(err, ErrUnsupportedSnapshot, "byte %d", i)
+		} else {
+			s.ErrorIs(err, ErrInvalidSnapshot, "byte %d", i)
+		}
+	}
+	for i := 0; i < len(data); i++ {
+		s.ErrorIs(other.LoadTree(bytes.NewReader(data[:i])), ErrInvalidSnapshot, "length %d", i)
+	}
+	s.ErrorIs(other.LoadTree(bytes.NewReader(append(append([]byte(nil), data...), 0))), ErrInvalidSnapshot)
+
+	// the rejected snapshots leave the tree untouched
+	root, err = other.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(otherRoot, root)
+	s.Equal(MembershipIndex(10), other.NextIndex())
+
+	_, err = readSnapshot(bytes.NewReader(data), 16)
+	s.ErrorIs(err, ErrInvalidParams)
+
+	// an empty tree can be saved too
+	empty, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer empty.Close()
+	emptyRoot, err := empty.GetMerkleRoot()
+	s.NoError(err)
+	snapshot.Reset()
+	s.NoError(empty.SaveTree(&snapshot))
+
+	loaded, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer loaded.Close()
+	s.NoError(loaded.LoadTree(&snapshot))
+	root, err = loaded.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(emptyRoot, root)
+	s.Equal(MembershipIndex(0), loaded.NextIndex())
+}
+
+func (s *RLNSuite) TestJournal() {
+	_, commitments := staticGroupCommitments(s)
+	dir := s.T().TempDir()
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	journal, err := OpenJournal(rln, dir)
+	s.NoError(err)
+
+	type state struct {
+		root MerkleNode
+		next MembershipIndex
+	}
+	current := func(r *RLN) state {
+		root, err := r.GetMerkleRoot()
+		s.NoError(err)
+		return state{root, r.NextIndex()}
+	}
+
+	// states[i] is the state of the tree after the first i records
+	states := []state{current(rln)}
+	for i := 0; i < 4; i++ {
+		index, err := journal.Insert(commitments[i])
+		s.NoError(err)
+		s.Equal(MembershipIndex(i), index)
+		states = append(states, current(rln))
+	}
+	s.NoError(journal.Delete(1))
+	states = append(states, current(rln))
+	s.NoError(journal.Set(9, commitments[9]))
+	states = append(states, current(rln))
+	s.NoError(journal.Set(12, commitments[12]))
+	states = append(states, current(rln))
+	s.Equal(uint64(7), journal.Sequence())
+
+	// invalid changes, and changes the rln lib does not support, are not recorded
+	invalid := IDCommitment{}
+	for i := range invalid {
+		invalid[i] = 0xff
+	}
+	s.ErrorIs(journal.Delete(MembershipIndex(1)<<MERKLE_TREE_DEPTH), ErrInvalidIndex)
+	s.ErrorIs(journal.Set(0, invalid), ErrInvalidCommitment)
+	s.ErrorIs(journal.Set(2, commitments[20]), ErrUnsupported)
+	s.ErrorIs(journal.Set(5, commitments[5]), ErrUnsupported)
+	s.Equal(uint64(7), journal.Sequence())
+	s.NoError(journal.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, JOURNAL_LOG_FILE))
+	s.NoError(err)
+	s.Len(data, 7*journalRecordSize)
+
+	// a crash can leave the log at any length, the complete records are replayed. The fake
+	// backend computes the same tree as the rln lib and is cheap to create for every length
+	for offset := 0; offset <= len(data); offset++ {
+		crashDir := s.T().TempDir()
+		s.NoError(ioutil.WriteFile(filepath.Join(crashDir, JOURNAL_LOG_FILE), data[:offset], 0600))
+
+		replayed := newFakeRLN(s)
+		j, err := OpenJournal(replayed, crashDir)
+		s.NoError(err, "offset %d", offset)
+
+		records := offset / journalRecordSize
+		s.Equal(states[records], current(replayed), "offset %d", offset)
+		s.Equal(uint64(records), j.Sequence())
+
+		info, err := os.Stat(filepath.Join(crashDir, JOURNAL_LOG_FILE))
+		s.NoError(err)
+		s.Equal(int64(records*journalRecordSize), info.Size(), "offset %d", offset)
+
+		// the log accepts new records after the recovery
+		_, err = j.Insert(commitments[50])
+		s.NoError(err)
+		s.Equal(uint64(records+1), j.Sequence())
+		s.NoError(j.Close())
+		s.NoError(replayed.Close())
+	}
+
+	// a corrupted record discards the records that follow it
+	corrupted := append([]byte(nil), data...)
+	corrupted[3*journalRecordSize+20] ^= 0x01
+	crashDir := s.T().TempDir()
+	s.NoError(ioutil.WriteFile(filepath.Join(crashDir, JOURNAL_LOG_FILE), corrupted, 0600))
+	replayed := newFakeRLN(s)
+	defer replayed.Close()
+	j, err := OpenJournal(replayed, crashDir)
+	s.NoError(err)
+	s.Equal(states[3], current(replayed))
+	s.NoError(j.Close())
+
+	// a checkpoint replaces the log with a snapshot
+	reopened, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer reopened.Close()
+	journal, err = OpenJournal(reopened, dir)
+	s.NoError(err)
+	s.Equal(states[7], current(reopened))
+	s.NoError(journal.Checkpoint())
+	info, err := os.Stat(filepath.Join(dir, JOURNAL_LOG_FILE))
+	s.NoError(err)
+	s.Equal(int64(0), info.Size())
+
+	// a crash between the snapshot and the truncation of the log leaves both, the records
+	// the snapshot holds are not replayed: the insertion of the member deleted by the next
+	// record would be a replacement the rln lib does not support
+	s.NoError(ioutil.WriteFile(filepath.Join(dir, JOURNAL_LOG_FILE), data, 0600))
+	s.NoError(journal.Close())
+	restored, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer restored.Close()
+	journal, err = OpenJournal(restored, dir)
+	s.NoError(err)
+	s.Equal(states[7], current(restored))
+	s.Equal(uint64(7), journal.Sequence())
+
+	// the records that follow the snapshot are replayed over it
+	_, err = journal.Insert(commitments[13])
+	s.NoError(err)
+	s.Equal(uint64(8), journal.Sequence())
+	final := current(restored)
+	s.NoError(journal.Close())
+
+	restored, err = NewRLN(s.parameters)
+	s.NoError(err)
+	defer restored.Close()
+	journal, err = OpenJournal(restored, dir)
+	s.NoError(err)
+	s.Equal(final, current(restored))
+	s.NoError(journal.Checkpoint())
+	s.NoError(journal.Close())
+
+	// a log that does not follow the snapshot is rejected
+	gap := journalRecord{seq: 10, op: JournalInsert, index: 14, idComm: commitments[14]}
+	s.NoError(ioutil.WriteFile(filepath.Join(dir, JOURNAL_LOG_FILE), gap.encode(), 0600))
+	restored, err = NewRLN(s.parameters)
+	s.NoError(err)
+	defer restored.Close()
+	_, err = OpenJournal(restored, dir)
+	s.Error(err)
+}
+
 func (s *RLNSuite) TestHash() {
-	rln, err := NewRLNWithDepth(32, s.parameters)
+	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
 
 	// prepare the input
@@ -168,6 +581,43 @@ func (s *RLNSuite) TestCheckCorrectness() {
 	s.Equal(expectedRoot, root[:])
 }
 
+func (s *RLNSuite) TestMerkleTreeMirror() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	groupKeyPairs, err := toMembershipKeyPairs(STATIC_GROUP_KEYS)
+	s.NoError(err)
+
+	for i, pair := range groupKeyPairs {
+		index, err := rln.Insert(pair.IDCommitment)
+		s.NoError(err)
+		s.Equal(MembershipIndex(i), index)
+	}
+
+	root, err := rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+	s.Equal(root, rln.tree.Root())
+
+	for _, index := range []MembershipIndex{0, 42, STATIC_GROUP_SIZE - 1} {
+		path, err := rln.MerklePath(index)
+		s.NoError(err)
+		s.True(merkle.VerifyPath(groupKeyPairs[index].IDCommitment, path, root))
+	}
+
+	// the mirror follows deletions
+	s.NoError(rln.Delete(42))
+	root, err = rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(root, rln.tree.Root())
+	s.Equal(uint(STATIC_GROUP_SIZE-1), rln.tree.Size())
+
+	path, err := rln.MerklePath(42)
+	s.NoError(err)
+	s.True(merkle.VerifyPath(IDCommitment{}, path, root))
+}
+
 func (s *RLNSuite) TestValidProof() {
 	rln, err := NewRLN(s.parameters)
 	s.NoError(err)
@@ -254,23 +704,1834 @@ func (s *RLNSuite) TestInvalidProof() {
 	s.False(verified)
 }
 
-func (s *RLNSuite) TestEpochConsistency() {
-	// check edge cases
-	var epoch uint64 = math.MaxUint64
-	epochBytes := ToEpoch(epoch)
-	decodedEpoch := epochBytes.Uint64()
+func (s *RLNSuite) TestFakeBackend() {
+	backend, err := NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
 
-	s.Equal(epoch, decodedEpoch)
+	rln, err := NewRLNWithBackend(backend)
+	s.NoError(err)
+	defer rln.Close()
+
+	_, err = NewRLNWithBackend(nil)
+	s.ErrorIs(err, ErrInvalidParams)
+
+	// the fake backend builds the same tree as the rln lib
+	keys, commitments := staticGroupCommitments(s)
+	s.NoError(rln.InsertAll(commitments))
+	root, err := rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+
+	key, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	idCommitment, err := poseidon.HashBytes(key.IDKey)
+	s.NoError(err)
+	s.Equal(idCommitment, key.IDCommitment)
+
+	msg := []byte("Hello")
+	epoch := ToEpoch(1)
+	proof, err := rln.GenerateProof(msg, keys[5], 5, epoch)
+	s.NoError(err)
+	s.Equal(root, proof.MerkleRoot)
+	s.Equal(epoch, proof.Epoch)
+
+	verified, err := rln.ValidateProof(msg, *proof)
+	s.NoError(err)
+	s.True(verified)
+	s.False(rln.Verify([]byte("Bye"), *proof))
+
+	tampered := *proof
+	tampered.ShareY[0] ^= 1
+	s.False(rln.Verify(msg, tampered))
+
+	// a proof for a key that is not at the index does not verify
+	proof, err = rln.GenerateProof(msg, keys[5], 4, epoch)
+	s.NoError(err)
+	s.False(rln.Verify(msg, *proof))
+
+	// the shares of two messages in the same epoch reveal the key
+	p1, err := rln.GenerateProof([]byte("first"), keys[7], 7, epoch)
+	s.NoError(err)
+	p2, err := rln.GenerateProof([]byte("second"), keys[7], 7, epoch)
+	s.NoError(err)
+	s.Equal(p1.Nullifier, p2.Nullifier)
+	recovered, err := RecoverMembershipKeyPair(p1.ExtractMetadata(), p2.ExtractMetadata())
+	s.NoError(err)
+	s.Equal(keys[7], recovered)
+
+	p3, err := rln.GenerateProof([]byte("first"), keys[7], 7, ToEpoch(2))
+	s.NoError(err)
+	s.NotEqual(p1.Nullifier, p3.Nullifier)
+
+	// a member set past a gap is in the tree of the backend
+	index := rln.NextIndex() + 2
+	s.NoError(rln.SetMember(index, key.IDCommitment))
+	proof, err = rln.GenerateProof(msg, *key, index, epoch)
+	s.NoError(err)
+	s.True(rln.Verify(msg, *proof))
+	backendRoot, err := backend.Root()
+	s.NoError(err)
+	s.Equal(backendRoot, proof.MerkleRoot)
+	s.NotEqual(root, proof.MerkleRoot)
+
+	s.NoError(rln.Close())
+	_, err = rln.GenerateProof(msg, *key, index, epoch)
+	s.ErrorIs(err, ErrClosed)
 }
 
-func (s *RLNSuite) TestEpochComparison() {
-	// check edge cases
-	var time1 uint64 = math.MaxUint64
-	var time2 uint64 = math.MaxUint64 - 1
+// blockingBackend is a FakeBackend whose proofs wait until a value is sent on `release`
+type blockingBackend struct {
+	*FakeBackend
+	started chan struct{}
+	release chan struct{}
+}
 
-	epoch1 := ToEpoch(time1)
-	epoch2 := ToEpoch(time2)
+func (b *blockingBackend) Prove(data []byte, key IDKey, index MembershipIndex, epoch Epoch) (RateLimitProof, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.FakeBackend.Prove(data, key, index, epoch)
+}
 
-	s.Equal(int64(1), Diff(epoch1, epoch2))
-	s.Equal(int64(-1), Diff(epoch2, epoch1))
+func (s *RLNSuite) TestProver() {
+	fake, err := NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
+	backend := &blockingBackend{FakeBackend: fake, started: make(chan struct{}, 10), release: make(chan struct{})}
+
+	rln, err := NewRLNWithBackend(backend)
+	s.NoError(err)
+	defer rln.Close()
+
+	keys, commitments := staticGroupCommitments(s)
+	s.NoError(rln.InsertAll(commitments))
+
+	var mu sync.Mutex
+	var depths []int
+	latencies := 0
+	prover := NewProver(rln, ProverConfig{
+		Workers:   1,
+		QueueSize: 2,
+		Hooks: ProverHooks{
+			QueueDepth: func(depth int) {
+				mu.Lock()
+				defer mu.Unlock()
+				depths = append(depths, depth)
+			},
+			Latency: func(wait, proving time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				latencies++
+			},
+		},
+	})
+	defer prover.Close()
+
+	msg := []byte("Hello")
+	epoch := ToEpoch(1)
+
+	// the worker takes the first request, the next two are queued and the last one is rejected
+	first := prover.GenerateProofAsync(context.Background(), msg, keys[0], 0, epoch)
+	<-backend.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := prover.GenerateProofAsync(ctx, msg, keys[1], 1, epoch)
+	second := prover.GenerateProofAsync(context.Background(), msg, keys[2], 2, epoch)
+	s.Equal(2, prover.QueueDepth())
+
+	result := <-prover.GenerateProofAsync(context.Background(), msg, keys[3], 3, epoch)
+	s.ErrorIs(result.Err, ErrQueueFull)
+	s.Nil(result.Proof)
+
+	// a cancelled request leaves the queue right away
+	cancel()
+	result = <-cancelled
+	s.ErrorIs(result.Err, context.Canceled)
+	s.Eventually(func() bool { return prover.QueueDepth() == 1 }, time.Second, time.Millisecond)
+
+	backend.release <- struct{}{}
+	result = <-first
+	s.NoError(result.Err)
+	s.True(rln.Verify(msg, *result.Proof))
+
+	<-backend.started
+	backend.release <- struct{}{}
+	result = <-second
+	s.NoError(result.Err)
+	s.True(rln.Verify(msg, *result.Proof))
+
+	_, open := <-second
+	s.False(open)
+
+	// the result of a request cancelled while its proof is generated is dropped
+	ctx, cancel = context.WithCancel(context.Background())
+	dropped := prover.GenerateProofAsync(ctx, msg, keys[4], 4, epoch)
+	<-backend.started
+	cancel()
+	backend.release <- struct{}{}
+	result = <-dropped
+	s.ErrorIs(result.Err, context.Canceled)
+	s.Nil(result.Proof)
+
+	mu.Lock()
+	s.Equal(3, latencies)
+	s.Equal([]int{1, 0, 1, 2, 1, 0, 1, 0}, depths)
+	mu.Unlock()
+
+	// shutting down fails the queued requests and waits for the proof being generated
+	inFlight := prover.GenerateProofAsync(context.Background(), msg, keys[5], 5, epoch)
+	<-backend.started
+	queued := prover.GenerateProofAsync(context.Background(), msg, keys[6], 6, epoch)
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	s.ErrorIs(prover.Shutdown(timeout), context.DeadlineExceeded)
+
+	result = <-queued
+	s.ErrorIs(result.Err, ErrClosed)
+	result = <-prover.GenerateProofAsync(context.Background(), msg, keys[7], 7, epoch)
+	s.ErrorIs(result.Err, ErrClosed)
+
+	backend.release <- struct{}{}
+	result = <-inFlight
+	s.NoError(result.Err)
+	s.NoError(prover.Shutdown(context.Background()))
+}
+
+// failingBackend fails to verify the proofs with the given nullifier
+type failingBackend struct {
+	Backend
+	nullifier Nullifier
+}
+
+func (b *failingBackend) Verify(data []byte, proof RateLimitProof) (bool, error) {
+	if proof.Nullifier == b.nullifier {
+		return false, errors.New("verification failed")
+	}
+	return b.Backend.Verify(data, proof)
+}
+
+func (s *RLNSuite) TestVerifyBatch() {
+	keys, commitments := staticGroupCommitments(s)
+
+	fake, err := NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
+	rln, err := NewRLNWithBackend(fake)
+	s.NoError(err)
+	s.NoError(rln.InsertAll(commitments))
+
+	var items []VerifyItem
+	for i := 0; i < 20; i++ {
+		msg := []byte(strconv.Itoa(i))
+		proof, err := rln.GenerateProof(msg, keys[i], MembershipIndex(i), ToEpoch(uint64(i)))
+		s.NoError(err)
+		items = append(items, VerifyItem{Data: msg, Proof: *proof})
+	}
+
+	results, err := rln.VerifyBatch(items)
+	s.NoError(err)
+	s.Len(results, len(items))
+	for _, verified := range results {
+		s.True(verified)
+	}
+
+	// the invalid items are identified
+	items[3].Data = []byte("tampered")
+	items[17].Proof.ShareY[0] ^= 1
+	results, err = rln.VerifyBatch(items)
+	s.NoError(err)
+	for i, verified := range results {
+		s.Equal(i != 3 && i != 17, verified, "item %d", i)
+	}
+
+	results, err = rln.VerifyBatch(nil)
+	s.NoError(err)
+	s.Empty(results)
+
+	s.NoError(rln.Close())
+	_, err = rln.VerifyBatch(items)
+	s.ErrorIs(err, ErrClosed)
+
+	// the error reports the item that could not be verified
+	fake, err = NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
+	backend := &failingBackend{Backend: fake}
+	rln, err = NewRLNWithBackend(backend)
+	s.NoError(err)
+	defer rln.Close()
+	s.NoError(rln.InsertAll(commitments))
+
+	items = nil
+	for i := 0; i < 5; i++ {
+		proof, err := rln.GenerateProof([]byte("Hello"), keys[i], MembershipIndex(i), ToEpoch(1))
+		s.NoError(err)
+		items = append(items, VerifyItem{Data: []byte("Hello"), Proof: *proof})
+	}
+	backend.nullifier = items[2].Proof.Nullifier
+	// the proofs of the same epoch have different nullifiers for different members
+	s.NotEqual(items[1].Proof.Nullifier, backend.nullifier)
+
+	results, err = rln.VerifyBatch(items)
+	s.ErrorIs(err, ErrFFI)
+	s.Nil(results)
+	var position *PositionError
+	s.True(errors.As(err, &position))
+	s.Equal(2, position.Position)
+}
+
+func (s *RLNSuite) TestDeriveMembershipKey() {
+	// test vector of BIP-39, https://github.com/trezor/python-mnemonic/blob/master/vectors.json
+	seed, err := mnemonicSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", "TREZOR")
+	s.NoError(err)
+	s.Equal("c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04", hex.EncodeToString(seed))
+
+	mnemonic := "legal winner thank year wave sausage worth useful legal winner thank yellow"
+	key, err := DeriveMembershipKeyFromMnemonic(mnemonic, "", DEFAULT_DERIVATION_PATH)
+	s.NoError(err)
+
+	// the derivation is deterministic, and does not depend on the spacing of the mnemonic
+	again, err := DeriveMembershipKeyFromMnemonic("  legal winner thank year wave sausage\nworth useful legal winner thank yellow ", "", "m/0h")
+	s.NoError(err)
+	s.Equal(key, again)
+
+	// the key is a field element and the commitment is its Poseidon hash
+	_, err = poseidon.FromBytes(key.IDKey)
+	s.NoError(err)
+	idCommitment, err := poseidon.HashBytes(key.IDKey)
+	s.NoError(err)
+	s.Equal(idCommitment, key.IDCommitment)
+
+	// every passphrase and path derives another key
+	seen := map[IDKey]bool{key.IDKey: true}
+	withPassphrase, err := DeriveMembershipKeyFromMnemonic(mnemonic, "TREZOR", DEFAULT_DERIVATION_PATH)
+	s.NoError(err)
+	s.False(seen[withPassphrase.IDKey])
+	seen[withPassphrase.IDKey] = true
+
+	seed = make([]byte, 32)
+	var keys []MembershipKeyPair
+	for _, path := range []string{"m", "m/0'", "m/1'", "m/0'/0'", "m/0'/1'", "m/2147483647'"} {
+		k, err := DeriveMembershipKey(seed, path)
+		s.NoError(err, path)
+		s.False(seen[k.IDKey], path)
+		seen[k.IDKey] = true
+		keys = append(keys, k)
+	}
+
+	// the keys have no public derivation, only hardened indexes are accepted
+	for _, path := range []string{"", "0'", "m/", "m//0'", "m/-1'", "m/0''", "m/2147483648'", "m/x'", "n/0'", "m/0", "m/0'/1"} {
+		_, err := DeriveMembershipKey(seed, path)
+		s.ErrorIs(err, ErrInvalidSeed, path)
+	}
+	_, err = DeriveMembershipKey(make([]byte, MIN_SEED_SIZE-1), DEFAULT_DERIVATION_PATH)
+	s.ErrorIs(err, ErrInvalidSeed)
+	_, err = DeriveMembershipKey(make([]byte, MAX_SEED_SIZE+1), DEFAULT_DERIVATION_PATH)
+	s.ErrorIs(err, ErrInvalidSeed)
+	_, err = DeriveMembershipKeyFromMnemonic("abandon abandon abandon", "", DEFAULT_DERIVATION_PATH)
+	s.ErrorIs(err, ErrInvalidSeed)
+	_, err = DeriveMembershipKeyFromMnemonic(mnemonic, "pässphrase", DEFAULT_DERIVATION_PATH)
+	s.ErrorIs(err, ErrInvalidSeed)
+
+	// a mistyped mnemonic is rejected, whether the word is unknown or the checksum does not match
+	for _, mistyped := range []string{
+		"legal winner thank year wave sausage worth useful legal winner thank yelow",
+		"legal winner thank year wave sausage worth useful legal winner thank year",
+		"winner legal thank year wave sausage worth useful legal winner thank yellow",
+	} {
+		_, err = DeriveMembershipKeyFromMnemonic(mistyped, "", DEFAULT_DERIVATION_PATH)
+		s.ErrorIs(err, ErrInvalidSeed, mistyped)
+	}
+
+	// the native tree accepts the derived commitments, and proofs of the derived keys verify
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	keys = append(keys, key)
+	for _, k := range keys {
+		_, err := rln.Insert(k.IDCommitment)
+		s.NoError(err)
+	}
+
+	root, err := rln.GetMerkleRoot()
+	s.NoError(err)
+	s.Equal(rln.tree.Root(), root)
+
+	index := MembershipIndex(len(keys) - 1)
+	proof, err := rln.GenerateProof([]byte("Hello"), key, index, ToEpoch(1))
+	s.NoError(err)
+	verified, err := rln.ValidateProof([]byte("Hello"), *proof)
+	s.NoError(err)
+	s.True(verified)
+}
+
+func (s *RLNSuite) TestStaticGroupKeyPairs() {
+	// every pair of the static group is valid
+	keys, err := StaticGroupKeyPairs()
+	s.NoError(err)
+	s.Len(keys, STATIC_GROUP_SIZE)
+	for _, key := range keys {
+		s.NoError(key.Validate())
+	}
+
+	// the returned list is a copy
+	keys[0] = MembershipKeyPair{}
+	again, err := StaticGroupKeyPairs()
+	s.NoError(err)
+	s.NoError(again[0].Validate())
+}
+
+func (s *RLNSuite) TestCommitmentFor() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	keys, err := toMembershipKeyPairs(STATIC_GROUP_KEYS)
+	s.NoError(err)
+	s.Len(keys, STATIC_GROUP_SIZE)
+
+	for _, key := range keys {
+		idCommitment, err := CommitmentFor(key.IDKey)
+		s.NoError(err)
+		s.Equal(key.IDCommitment, idCommitment)
+		s.NoError(key.Validate())
+	}
+
+	// the keys generated by the rln lib hold the same relation
+	generated, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	s.NoError(generated.Validate())
+
+	tampered := keys[0]
+	tampered.IDCommitment[0] ^= 1
+	s.ErrorIs(tampered.Validate(), ErrInvalidCommitment)
+
+	var invalid IDKey
+	for i := range invalid {
+		invalid[i] = 0xff
+	}
+	_, err = CommitmentFor(invalid)
+	s.ErrorIs(err, ErrInvalidKey)
+	s.ErrorIs(MembershipKeyPair{IDKey: invalid}.Validate(), ErrInvalidKey)
+
+	// a corrupted group is rejected when it is loaded
+	corrupted := [][]string{STATIC_GROUP_KEYS[0], {STATIC_GROUP_KEYS[1][0], STATIC_GROUP_KEYS[2][1]}}
+	_, err = toMembershipKeyPairs(corrupted)
+	s.ErrorIs(err, ErrInvalidCommitment)
+	s.Contains(err.Error(), "key pair 1")
+
+	for _, pair := range [][]string{
+		{STATIC_GROUP_KEYS[0][0]},
+		{STATIC_GROUP_KEYS[0][0][2:], STATIC_GROUP_KEYS[0][1]},
+		{STATIC_GROUP_KEYS[0][0], "zz" + STATIC_GROUP_KEYS[0][1][2:]},
+	} {
+		_, err = toMembershipKeyPairs([][]string{pair})
+		s.Error(err)
+	}
+
+	// the commitment does not depend on an instance
+	s.NoError(rln.Close())
+	idCommitment, err := CommitmentFor(keys[0].IDKey)
+	s.NoError(err)
+	s.Equal(keys[0].IDCommitment, idCommitment)
+}
+
+func leBytes(v *big.Int) [32]byte {
+	var b [32]byte
+	be := v.Bytes()
+	for i := range be {
+		b[i] = be[len(be)-1-i]
+	}
+	return b
+}
+
+func (s *RLNSuite) TestField() {
+	modulus := FieldModulus()
+	s.Equal("21888242871839275222246405745257275088548364400416034343698204186575808495617", modulus.String())
+
+	one := big.NewInt(1)
+	maxValue := new(big.Int).Sub(new(big.Int).Lsh(one, 256), one)
+	below := new(big.Int).Sub(modulus, one)
+	above := new(big.Int).Add(modulus, one)
+
+	// the values at and above the modulus are rejected instead of being reduced
+	for _, v := range []*big.Int{modulus, above, maxValue} {
+		s.False(IsCanonical(leBytes(v)), v.String())
+		_, err := FromBytesLE(leBytes(v))
+		s.ErrorIs(err, ErrInvalidField)
+		_, err = FromBig(v)
+		s.ErrorIs(err, ErrInvalidField)
+	}
+	_, err := FromBig(big.NewInt(-1))
+	s.ErrorIs(err, ErrInvalidField)
+
+	for _, v := range []*big.Int{big.NewInt(0), one, below} {
+		s.True(IsCanonical(leBytes(v)), v.String())
+		f, err := FromBytesLE(leBytes(v))
+		s.NoError(err)
+		s.Equal(0, v.Cmp(f.Big()))
+		s.Equal(leBytes(v), f.Bytes())
+		s.Equal(v.String(), f.String())
+
+		g, err := FromBig(v)
+		s.NoError(err)
+		s.True(f.Equal(g))
+	}
+
+	// the encoding is little endian: the last byte of the modulus is its most significant one
+	s.Equal(byte(0x30), leBytes(modulus)[31])
+
+	// the arithmetic wraps around the modulus
+	zero := Field{}
+	s.True(zero.IsZero())
+	oneF, err := FromBig(one)
+	s.NoError(err)
+	last, err := FromBig(below)
+	s.NoError(err)
+	s.True(last.Add(oneF).IsZero())
+	s.True(zero.Sub(oneF).Equal(last))
+	s.True(last.Mul(last).Equal(oneF))
+
+	_, err = zero.Inverse()
+	s.ErrorIs(err, ErrInvalidField)
+	inverse, err := last.Inverse()
+	s.NoError(err)
+	s.True(inverse.Equal(last))
+
+	x, err := FromBig(big.NewInt(12345))
+	s.NoError(err)
+	inverse, err = x.Inverse()
+	s.NoError(err)
+	s.True(x.Mul(inverse).Equal(oneF))
+	s.True(x.Add(last).Sub(x).Equal(last))
+
+	// the public entry points reject values that are not field elements
+	backend, err := NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
+	rln, err := NewRLNWithBackend(backend)
+	s.NoError(err)
+	defer rln.Close()
+
+	_, err = rln.Insert(leBytes(modulus))
+	s.ErrorIs(err, ErrInvalidCommitment)
+	s.False(rln.InsertMember(leBytes(maxValue)))
+	s.ErrorIs(rln.InsertAll([]IDCommitment{leBytes(below), leBytes(above)}), ErrInvalidCommitment)
+	s.ErrorIs(rln.InsertMembers(rln.NextIndex(), []IDCommitment{leBytes(modulus)}), ErrInvalidCommitment)
+	s.ErrorIs(rln.SetMember(5, leBytes(modulus)), ErrInvalidCommitment)
+	_, err = CommitmentFor(leBytes(modulus))
+	s.ErrorIs(err, ErrInvalidKey)
+
+	// the rejected commitments left the tree untouched: only the value below the modulus was inserted
+	s.Equal(MembershipIndex(1), rln.NextIndex())
+	member, err := rln.GetMember(0)
+	s.NoError(err)
+	s.Equal(leBytes(below), member)
+
+	key, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	index, err := rln.Insert(key.IDCommitment)
+	s.NoError(err)
+
+	msg := []byte("Hello")
+	_, err = rln.GenerateProof(msg, MembershipKeyPair{IDKey: leBytes(modulus), IDCommitment: key.IDCommitment}, index, ToEpoch(1))
+	s.ErrorIs(err, ErrInvalidKey)
+	_, err = rln.GenerateProof(msg, *key, index, Epoch(leBytes(modulus)))
+	s.ErrorIs(err, ErrInvalidField)
+
+	proof, err := rln.GenerateProof(msg, *key, index, Epoch(leBytes(below)))
+	s.NoError(err)
+	verified, err := rln.VerifyProof(msg, *proof)
+	s.NoError(err)
+	s.True(verified)
+
+	mutations := []func(p *RateLimitProof){
+		func(p *RateLimitProof) { p.MerkleRoot = leBytes(modulus) },
+		func(p *RateLimitProof) { p.Epoch = Epoch(leBytes(above)) },
+		func(p *RateLimitProof) { p.ShareX = leBytes(maxValue) },
+		func(p *RateLimitProof) { p.ShareY = leBytes(modulus) },
+		func(p *RateLimitProof) { p.Nullifier = leBytes(modulus) },
+	}
+	items := []VerifyItem{{Data: msg, Proof: *proof}}
+	for i, mutate := range mutations {
+		malformed := *proof
+		mutate(&malformed)
+		verified, err := rln.VerifyProof(msg, malformed)
+		s.ErrorIs(err, ErrInvalidProof, "mutation %d", i)
+		s.False(verified)
+		s.False(rln.Verify(msg, malformed))
+		items = append(items, VerifyItem{Data: msg, Proof: malformed})
+	}
+
+	// a batch reports the malformed proofs as invalid, and the position of the first of them
+	results, err := rln.VerifyBatch(items)
+	s.ErrorIs(err, ErrInvalidProof)
+	var position *PositionError
+	s.True(errors.As(err, &position))
+	s.Equal(1, position.Position)
+	s.Equal([]bool{true, false, false, false, false, false}, results)
+}
+
+func (s *RLNSuite) TestEpochConsistency() {
+	// check edge cases
+	var epoch uint64 = math.MaxUint64
+	epochBytes := ToEpoch(epoch)
+	decodedEpoch := epochBytes.Uint64()
+
+	s.Equal(epoch, decodedEpoch)
+}
+
+func (s *RLNSuite) TestEpochComparison() {
+	// check edge cases
+	var time1 uint64 = math.MaxUint64
+	var time2 uint64 = math.MaxUint64 - 1
+
+	epoch1 := ToEpoch(time1)
+	epoch2 := ToEpoch(time2)
+
+	s.Equal(int64(1), Diff(epoch1, epoch2))
+	s.Equal(int64(-1), Diff(epoch2, epoch1))
+}
+
+func (s *RLNSuite) TestParams() {
+	path := filepath.Join("testdata", "parameters.key")
+	params, err := LoadParams(path)
+	s.NoError(err)
+	s.Equal(s.parameters, params.Bytes())
+	s.Equal("be13c7c155313b42a4efbbdd590f3634d7b9a203f554ad604b8eec85c5ebda7a", params.Fingerprint())
+	s.NoError(params.CheckFingerprint("0xBE13C7C155313B42A4EFBBDD590F3634D7B9A203F554AD604B8EEC85C5EBDA7A"))
+	s.ErrorIs(params.CheckFingerprint(strings.Repeat("00", 32)), ErrInvalidParams)
+
+	depth, ok := params.Depth()
+	s.True(ok)
+	s.Equal(MERKLE_TREE_DEPTH, depth)
+
+	// the parameters are shared by all the loaders
+	parsed, err := ParseParams(bytes.NewReader(s.parameters))
+	s.NoError(err)
+	s.True(params == parsed)
+
+	rln, err := NewRLNWithParams(params)
+	s.NoError(err)
+	defer rln.Close()
+
+	// the depth must match the parameters
+	_, err = NewRLNWithDepth(32, s.parameters)
+	s.ErrorIs(err, ErrInvalidParams)
+	s.Contains(err.Error(), "generated for a Merkle tree of depth 20, not 32")
+
+	// corrupted files are rejected before reaching the rln lib
+	_, err = ParseParams(bytes.NewReader(s.parameters[:len(s.parameters)-1]))
+	s.ErrorIs(err, ErrInvalidParams)
+	s.Contains(err.Error(), "truncated parameters")
+
+	_, err = ParseParams(bytes.NewReader(append(append([]byte(nil), s.parameters...), 0)))
+	s.ErrorIs(err, ErrInvalidParams)
+	s.Contains(err.Error(), "trailing bytes")
+
+	_, err = ParseParams(bytes.NewReader(s.parameters[:100]))
+	s.ErrorIs(err, ErrInvalidParams)
+
+	_, err = LoadParams(filepath.Join("testdata", "missing.key"))
+	s.ErrorIs(err, ErrInvalidParams)
+
+	// the depth of other parameters can be recorded
+	other := append([]byte(nil), s.parameters...)
+	other[len(other)-1] ^= 1
+	otherParams, err := ParseParams(bytes.NewReader(other))
+	s.NoError(err)
+	_, ok = otherParams.Depth()
+	s.False(ok)
+
+	s.NoError(RegisterParamsDepth(otherParams.Fingerprint(), 16))
+	depth, ok = otherParams.Depth()
+	s.True(ok)
+	s.Equal(16, depth)
+	_, err = NewRLNWithDepth(20, other)
+	s.ErrorIs(err, ErrInvalidParams)
+
+	s.ErrorIs(RegisterParamsDepth("abcd", 16), ErrInvalidParams)
+	s.ErrorIs(RegisterParamsDepth(otherParams.Fingerprint(), 0), ErrInvalidParams)
+}
+
+// fakeClock is a Clock whose time only changes when it is advanced
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func (s *RLNSuite) TestEpochConfig() {
+	// the package level functions keep the 10 seconds epochs
+	s.Equal(ToEpoch(123), CalcEpoch(time.Unix(1239, 999)))
+	s.Equal(time.Unix(1230, 0), ToEpoch(123).Time())
+	// distant epochs do not overflow a time.Duration
+	s.Equal(time.Unix(1e12, 0), ToEpoch(1e11).Time())
+	s.Equal(ToEpoch(1e11), CalcEpoch(time.Unix(1e12, 0)))
+	s.Equal(ToEpoch(1e11-1), CalcEpoch(time.Unix(1e12-1, 999999999)))
+
+	genesis := time.Date(2022, 9, 15, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: genesis.Add(30 * time.Second)}
+	slots := EpochConfig{Unit: 12 * time.Second, Genesis: genesis, Clock: clock}
+
+	s.Equal(ToEpoch(2), slots.Current())
+	s.Equal(genesis.Add(24*time.Second), slots.Start(slots.Current()))
+	s.Equal(genesis.Add(36*time.Second), slots.End(slots.Current()))
+	s.Equal(6*time.Second, slots.Until(ToEpoch(3)))
+	s.Equal(-6*time.Second, slots.Until(ToEpoch(2)))
+
+	// the end of an epoch is the start of the next one
+	clock.Advance(6 * time.Second)
+	s.Equal(ToEpoch(3), slots.Current())
+	clock.Advance(-time.Nanosecond)
+	s.Equal(ToEpoch(2), slots.Current())
+
+	// times before genesis belong to the first epoch
+	s.Equal(ToEpoch(0), slots.At(genesis.Add(-time.Hour)))
+
+	chat := EpochConfig{Unit: time.Second, Clock: clock}
+	s.Equal(ToEpoch(uint64(clock.Now().Unix())), chat.Current())
+	s.Equal(time.Unix(1000, 0), chat.Start(ToEpoch(1000)))
+
+	sub := EpochConfig{Unit: 1500 * time.Millisecond, Clock: clock}
+	s.Equal(ToEpoch(2), sub.At(time.Unix(3, 0)))
+	s.Equal(time.Unix(4, 500000000), sub.End(ToEpoch(2)))
+
+	// far future times map to the epoch that starts before them, past the 292 years a time.Duration holds
+	far := EpochConfig{Unit: 1500 * time.Millisecond, Genesis: genesis.Add(time.Nanosecond)}
+	for _, t := range []time.Time{
+		genesis.AddDate(300, 0, 0),
+		genesis.AddDate(100000, 0, 0).Add(123456789),
+		time.Unix(1<<62, 999999999),
+	} {
+		epoch := far.At(t)
+		s.False(far.Start(epoch).After(t), t)
+		s.True(far.End(epoch).After(t), t)
+		s.Equal(epoch, far.At(far.Start(epoch)), t)
+		s.NotEqual(epoch, far.At(t.AddDate(1, 0, 0)), t)
+	}
+
+	// the epochs that do not fit in 64 bits, and the starts a time.Time can not hold, are clamped
+	latest := time.Unix(maxUnixSeconds, 0)
+	nano := EpochConfig{Unit: time.Nanosecond, Genesis: time.Unix(math.MinInt64/2, 0)}
+	s.Equal(ToEpoch(math.MaxUint64), nano.At(time.Unix(1<<62, 0)))
+	s.Equal(ToEpoch(math.MaxUint64), nano.At(latest))
+	s.True(latest.Equal(far.Start(ToEpoch(math.MaxUint64))))
+	s.True(latest.Equal(slots.End(ToEpoch(math.MaxUint64/12))))
+	s.True(latest.Equal(slots.End(ToEpoch(math.MaxUint64))))
+
+	// the epochs are computed without allocating
+	s.Zero(testing.AllocsPerRun(100, func() {
+		far.Start(far.At(time.Unix(1<<62, 999999999)))
+	}))
+
+	// the zero value uses the defaults
+	var defaults EpochConfig
+	s.Equal(CalcEpoch(genesis), defaults.At(genesis))
+	s.Equal(ToEpoch(123).Time(), defaults.Start(ToEpoch(123)))
+}
+
+func (s *RLNSuite) TestClose() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+
+	s.NoError(rln.Close())
+	// closing twice is a no-op
+	s.NoError(rln.Close())
+
+	_, err = rln.MembershipKeyGen()
+	s.ErrorIs(err, ErrClosed)
+
+	_, err = rln.GetMerkleRoot()
+	s.ErrorIs(err, ErrClosed)
+
+	_, err = rln.Hash([]byte("Hello"))
+	s.ErrorIs(err, ErrClosed)
+
+	_, err = rln.GenerateProof([]byte("Hello"), MembershipKeyPair{}, 0, Epoch{})
+	s.ErrorIs(err, ErrClosed)
+
+	s.False(rln.InsertMember(IDCommitment{}))
+	s.False(rln.DeleteMember(0))
+	s.False(rln.AddAll([]IDCommitment{{}}))
+	s.False(rln.Verify([]byte("Hello"), RateLimitProof{}))
+}
+
+func (s *RLNSuite) TestConcurrentAccess() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	memKeys, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	s.True(rln.InsertMember(memKeys.IDCommitment))
+
+	msg := []byte("Hello")
+	proof, err := rln.GenerateProof(msg, *memKeys, MembershipIndex(0), GetCurrentEpoch())
+	s.NoError(err)
+
+	// the proof is checked against the root it carries, so it stays valid while the tree changes
+	iterations := 50
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	for w := 0; w < 4; w++ {
+		run(func(i int) {
+			keys, err := rln.MembershipKeyGen()
+			s.NoError(err)
+			s.True(rln.InsertMember(keys.IDCommitment))
+		})
+		run(func(i int) {
+			s.True(rln.DeleteMember(MembershipIndex(1 + i)))
+		})
+		run(func(i int) {
+			root, err := rln.GetMerkleRoot()
+			s.NoError(err)
+			s.Len(root, 32)
+		})
+		run(func(i int) {
+			s.True(rln.Verify(msg, *proof))
+		})
+	}
+
+	wg.Wait()
+}
+
+func (s *RLNSuite) TestErrors() {
+	_, err := NewRLN(nil)
+	s.ErrorIs(err, ErrInvalidParams)
+
+	_, err = NewRLNWithDepth(0, s.parameters)
+	s.ErrorIs(err, ErrInvalidParams)
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+
+	memKeys, err := rln.MembershipKeyGen()
+	s.NoError(err)
+
+	index, err := rln.Insert(memKeys.IDCommitment)
+	s.NoError(err)
+	s.Equal(MembershipIndex(0), index)
+
+	index, err = rln.Insert(memKeys.IDCommitment)
+	s.NoError(err)
+	s.Equal(MembershipIndex(1), index)
+
+	outOfBounds := MembershipIndex(1) << MERKLE_TREE_DEPTH
+	err = rln.Delete(outOfBounds)
+	s.ErrorIs(err, ErrInvalidIndex)
+	s.False(rln.DeleteMember(outOfBounds))
+
+	var rlnErr *Error
+	s.ErrorAs(err, &rlnErr)
+	s.Equal("Delete", rlnErr.Op)
+	s.Equal(ErrInvalidIndex, rlnErr.Kind)
+
+	_, err = rln.GenerateProof([]byte("Hello"), *memKeys, outOfBounds, Epoch{})
+	s.ErrorIs(err, ErrInvalidIndex)
+
+	// an invalid proof is not an error
+	proof, err := rln.GenerateProof([]byte("Hello"), *memKeys, MembershipIndex(0), Epoch{})
+	s.NoError(err)
+	verified, err := rln.VerifyProof([]byte("Bye"), *proof)
+	s.NoError(err)
+	s.False(verified)
+
+	s.NoError(rln.Close())
+
+	_, err = rln.Insert(memKeys.IDCommitment)
+	s.ErrorIs(err, ErrClosed)
+	s.NotErrorIs(err, ErrTreeFull)
+
+	err = rln.InsertAll([]IDCommitment{memKeys.IDCommitment})
+	s.ErrorIs(err, ErrClosed)
+	s.ErrorAs(err, &rlnErr)
+	s.Equal("InsertAll", rlnErr.Op)
+
+	_, err = rln.VerifyProof([]byte("Hello"), *proof)
+	s.ErrorIs(err, ErrClosed)
+}
+
+func testProof() RateLimitProof {
+	var proof RateLimitProof
+	for i := range proof.Proof {
+		proof.Proof[i] = byte(i)
+	}
+	proof.MerkleRoot = Bytes32([]byte{1, 2, 3})
+	proof.Epoch = ToEpoch(12345)
+	proof.ShareX = Bytes32([]byte{4, 5, 6})
+	proof.ShareY = Bytes32([]byte{7, 8, 9})
+	proof.Nullifier = Bytes32([]byte{10, 11, 12})
+	return proof
+}
+
+func (s *RLNSuite) TestRateLimitProofEncoding() {
+	proof := testProof()
+
+	b, err := proof.MarshalBinary()
+	s.NoError(err)
+	s.Len(b, RateLimitProofSize)
+	s.Equal(proof.Proof[:], b[:256])
+	s.Equal(proof.Nullifier[:], b[384:])
+
+	var decoded RateLimitProof
+	s.NoError(decoded.UnmarshalBinary(b))
+	s.Equal(proof, decoded)
+
+	text, err := proof.MarshalText()
+	s.NoError(err)
+	s.Equal(hex.EncodeToString(b), string(text))
+
+	decoded = RateLimitProof{}
+	s.NoError(decoded.UnmarshalText(text))
+	s.Equal(proof, decoded)
+
+	jsonBytes, err := json.Marshal(proof)
+	s.NoError(err)
+
+	var fields map[string]string
+	s.NoError(json.Unmarshal(jsonBytes, &fields))
+	s.Equal(hex.EncodeToString(proof.MerkleRoot[:]), fields["merkleRoot"])
+	s.Equal(hex.EncodeToString(proof.Epoch[:]), fields["epoch"])
+	s.Len(fields, 6)
+
+	decoded = RateLimitProof{}
+	s.NoError(json.Unmarshal(jsonBytes, &decoded))
+	s.Equal(proof, decoded)
+
+	// the proof can be embedded in other messages
+	type envelope struct {
+		Payload string          `json:"payload"`
+		Proof   *RateLimitProof `json:"proof"`
+	}
+	jsonBytes, err = json.Marshal(envelope{Payload: "Hello", Proof: &proof})
+	s.NoError(err)
+	var env envelope
+	s.NoError(json.Unmarshal(jsonBytes, &env))
+	s.Equal(proof, *env.Proof)
+}
+
+func (s *RLNSuite) TestRateLimitProofDecodingMalformed() {
+	proof := testProof()
+	b, _ := proof.MarshalBinary()
+	text, _ := proof.MarshalText()
+
+	var decoded RateLimitProof
+	s.ErrorIs(decoded.UnmarshalBinary(nil), ErrInvalidProof)
+	s.ErrorIs(decoded.UnmarshalBinary(b[:RateLimitProofSize-1]), ErrInvalidProof)
+	s.ErrorIs(decoded.UnmarshalBinary(append(b, 0)), ErrInvalidProof)
+
+	s.ErrorIs(decoded.UnmarshalText(text[:len(text)-2]), ErrInvalidProof)
+	s.ErrorIs(decoded.UnmarshalText(append([]byte("0x"), text[2:]...)), ErrInvalidProof)
+	badText := append([]byte{}, text...)
+	badText[10] = 'z'
+	s.ErrorIs(decoded.UnmarshalText(badText), ErrInvalidProof)
+
+	jsonBytes, _ := json.Marshal(proof)
+	var fields map[string]string
+	s.NoError(json.Unmarshal(jsonBytes, &fields))
+
+	malformed := []func(map[string]string){
+		func(f map[string]string) { delete(f, "nullifier") },
+		func(f map[string]string) { f["epoch"] = f["epoch"][2:] },
+		func(f map[string]string) { f["shareX"] = f["shareX"] + "00" },
+		func(f map[string]string) { f["proof"] = "zz" + f["proof"][2:] },
+		func(f map[string]string) { f["signal"] = "00" },
+	}
+	for _, m := range malformed {
+		copied := make(map[string]string)
+		for k, v := range fields {
+			copied[k] = v
+		}
+		m(copied)
+		jsonBytes, _ := json.Marshal(copied)
+		s.ErrorIs(json.Unmarshal(jsonBytes, &decoded), ErrInvalidProof)
+	}
+
+	s.ErrorIs(json.Unmarshal([]byte(`{"proof": 1}`), &decoded), ErrInvalidProof)
+	s.ErrorIs(decoded.UnmarshalJSON(append(jsonBytes, []byte(`{}`)...)), ErrInvalidProof)
+	s.NoError(decoded.UnmarshalJSON(jsonBytes))
+	s.Equal(proof, decoded)
+
+	// a failed decoding leaves the destination untouched
+	decoded = RateLimitProof{}
+	s.Error(json.Unmarshal([]byte(`{"proof": "00"}`), &decoded))
+	s.Equal(RateLimitProof{}, decoded)
+}
+
+func (s *RLNSuite) TestNullifierLog() {
+	log := NewNullifierLog(2)
+
+	epoch := ToEpoch(100)
+	m1 := ProofMetadata{Nullifier: Bytes32([]byte{1}), ShareX: Bytes32([]byte{2}), ShareY: Bytes32([]byte{3})}
+	m2 := ProofMetadata{Nullifier: m1.Nullifier, ShareX: Bytes32([]byte{4}), ShareY: Bytes32([]byte{5})}
+	m3 := ProofMetadata{Nullifier: Bytes32([]byte{6}), ShareX: Bytes32([]byte{7}), ShareY: Bytes32([]byte{8})}
+
+	status, _ := log.Check(epoch, m1)
+	s.Equal(ProofFresh, status)
+
+	status, _ = log.Add(epoch, m1)
+	s.Equal(ProofFresh, status)
+
+	// the same proof again
+	status, previous := log.Add(epoch, m1)
+	s.Equal(ProofDuplicate, status)
+	s.True(previous.Equals(m1))
+
+	// same nullifier, different shares
+	status, _ = log.Check(epoch, m2)
+	s.Equal(ProofDoubleSignal, status)
+	status, previous = log.Add(epoch, m2)
+	s.Equal(ProofDoubleSignal, status)
+	s.True(previous.Equals(m1))
+	s.Equal("double signal", status.String())
+
+	// the double signal itself is detected as a duplicate when relayed again
+	status, _ = log.Add(epoch, m2)
+	s.Equal(ProofDuplicate, status)
+
+	// other nullifiers and other epochs are independent
+	status, _ = log.Add(epoch, m3)
+	s.Equal(ProofFresh, status)
+	status, _ = log.Add(ToEpoch(101), m1)
+	s.Equal(ProofFresh, status)
+
+	s.Equal(4, log.Len())
+
+	// epoch 100 is still in the window of epoch 101
+	log.Prune(ToEpoch(101))
+	s.Equal(4, log.Len())
+
+	log.Prune(ToEpoch(102))
+	s.Equal(1, log.Len())
+	status, _ = log.Check(epoch, m1)
+	s.Equal(ProofOutOfWindow, status)
+
+	// once pruned, the epochs out of the window are not recorded, in the past nor in the future
+	for _, e := range []Epoch{ToEpoch(100), ToEpoch(104), ToEpoch(math.MaxUint64), {0: 103, 16: 1}} {
+		status, _ = log.Add(e, m3)
+		s.Equal(ProofOutOfWindow, status, e.Uint64())
+	}
+	s.Equal("out of window", status.String())
+	status, _ = log.Add(ToEpoch(103), m3)
+	s.Equal(ProofFresh, status)
+	s.Equal(2, log.Len())
+
+	// future epochs recorded before the log is pruned are pruned as well
+	log = NewNullifierLog(2)
+	for _, e := range []Epoch{ToEpoch(100), ToEpoch(101), ToEpoch(1000), ToEpoch(math.MaxUint64)} {
+		status, _ = log.Add(e, m1)
+		s.Equal(ProofFresh, status)
+	}
+	log.Prune(ToEpoch(100))
+	s.Equal(2, log.Len())
+	log.Prune(ToEpoch(1001))
+	s.Equal(0, log.Len())
+}
+
+func (s *RLNSuite) TestExtractMetadata() {
+	proof := testProof()
+	metadata := proof.ExtractMetadata()
+	s.Equal(proof.Nullifier, metadata.Nullifier)
+	s.Equal(proof.ShareX, metadata.ShareX)
+	s.Equal(proof.ShareY, metadata.ShareY)
+}
+
+// share computes a point of the line y = a_0 + a_1 * x
+func share(a0, a1 fr.Element, x uint64) (MerkleNode, MerkleNode) {
+	shareX := fr.NewElement(x)
+	var shareY fr.Element
+	shareY.Mul(&a1, &shareX)
+	shareY.Add(&shareY, &a0)
+	return poseidon.ToBytes(shareX), poseidon.ToBytes(shareY)
+}
+
+func (s *RLNSuite) TestRecoverIDKeyFromShares() {
+	groupKeyPairs, err := toMembershipKeyPairs(STATIC_GROUP_KEYS)
+	s.NoError(err)
+	expected := groupKeyPairs[7]
+
+	a0, err := poseidon.FromBytes(expected.IDKey)
+	s.NoError(err)
+	a1 := fr.NewElement(123456789)
+
+	nullifier := Bytes32([]byte{1, 2, 3})
+	p1 := ProofMetadata{Nullifier: nullifier}
+	p1.ShareX, p1.ShareY = share(a0, a1, 10)
+	p2 := ProofMetadata{Nullifier: nullifier}
+	p2.ShareX, p2.ShareY = share(a0, a1, 20)
+
+	idKey, err := RecoverIDKey(p1, p2)
+	s.NoError(err)
+	s.Equal(expected.IDKey, idKey)
+
+	keyPair, err := RecoverMembershipKeyPair(p2, p1)
+	s.NoError(err)
+	s.Equal(expected, keyPair)
+
+	// the same message can not be used to recover the key
+	_, err = RecoverIDKey(p1, p1)
+	s.ErrorIs(err, ErrInvalidShares)
+
+	// nor messages of different members or epochs
+	p3 := p2
+	p3.Nullifier = Bytes32([]byte{4, 5, 6})
+	_, err = RecoverIDKey(p1, p3)
+	s.ErrorIs(err, ErrInvalidShares)
+
+	p3 = p2
+	for i := range p3.ShareY {
+		p3.ShareY[i] = 0xff
+	}
+	_, err = RecoverMembershipKeyPair(p1, p3)
+	s.ErrorIs(err, ErrInvalidShares)
+}
+
+func (s *RLNSuite) TestRecoverIDKeyFromProofs() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	index := 3
+	var memKeys *MembershipKeyPair
+	for i := 0; i < 5; i++ {
+		keys, err := rln.MembershipKeyGen()
+		s.NoError(err)
+		s.True(rln.InsertMember(keys.IDCommitment))
+		if i == index {
+			memKeys = keys
+		}
+	}
+
+	epoch := ToEpoch(1000)
+	proof1, err := rln.GenerateProof([]byte("Hello"), *memKeys, MembershipIndex(index), epoch)
+	s.NoError(err)
+	proof2, err := rln.GenerateProof([]byte("World"), *memKeys, MembershipIndex(index), epoch)
+	s.NoError(err)
+
+	// both messages are valid, but they share the nullifier
+	s.True(rln.Verify([]byte("Hello"), *proof1))
+	s.True(rln.Verify([]byte("World"), *proof2))
+	s.Equal(proof1.Nullifier, proof2.Nullifier)
+
+	log := NewNullifierLog(1)
+	status, _ := log.Add(epoch, proof1.ExtractMetadata())
+	s.Equal(ProofFresh, status)
+	status, previous := log.Add(epoch, proof2.ExtractMetadata())
+	s.Equal(ProofDoubleSignal, status)
+
+	keyPair, err := RecoverMembershipKeyPair(previous, proof2.ExtractMetadata())
+	s.NoError(err)
+	s.Equal(*memKeys, keyPair)
+
+	// the recovered commitment locates the member in the tree
+	spammerIndex, ok := rln.IndexOf(keyPair.IDCommitment)
+	s.True(ok)
+	s.Equal(MembershipIndex(index), spammerIndex)
+	s.True(rln.DeleteMember(spammerIndex))
+
+	_, ok = rln.IndexOf(keyPair.IDCommitment)
+	s.False(ok)
+}
+
+// newFakeRLN returns an instance relying on the fake backend, which does not need parameters
+func newFakeRLN(s *RLNSuite) *RLN {
+	backend, err := NewFakeBackend(MERKLE_TREE_DEPTH)
+	s.NoError(err)
+	rln, err := NewRLNWithBackend(backend)
+	s.NoError(err)
+	return rln
+}
+
+func staticGroupCommitments(s *RLNSuite) ([]MembershipKeyPair, []IDCommitment) {
+	groupKeyPairs, err := StaticGroupKeyPairs()
+	s.NoError(err)
+
+	var commitments []IDCommitment
+	for _, pair := range groupKeyPairs {
+		commitments = append(commitments, pair.IDCommitment)
+	}
+	return groupKeyPairs, commitments
+}
+
+func (s *RLNSuite) TestStaticGroup() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	groupKeyPairs, commitments := staticGroupCommitments(s)
+
+	var group GroupManager
+	group, err = NewStaticGroup(rln, commitments, &groupKeyPairs[12])
+	s.NoError(err)
+	s.Equal(rln, group.RLN())
+
+	root, err := group.Root()
+	s.NoError(err)
+	s.Equal(STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+
+	index, ok := group.OwnIndex()
+	s.True(ok)
+	s.Equal(MembershipIndex(12), index)
+
+	index, ok = group.IndexOf(commitments[99])
+	s.True(ok)
+	s.Equal(MembershipIndex(99), index)
+
+	_, ok = group.IndexOf(IDCommitment{1})
+	s.False(ok)
+
+	// a commitment listed twice is rejected
+	other, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer other.Close()
+	_, err = NewStaticGroup(other, append(commitments[:3:3], commitments[1]), nil)
+	s.ErrorIs(err, ErrDuplicateMember)
+}
+
+func (s *RLNSuite) TestGroupFile() {
+	_, commitments := staticGroupCommitments(s)
+
+	var encoded []string
+	for _, c := range commitments {
+		encoded = append(encoded, hex.EncodeToString(c[:]))
+	}
+
+	dir := s.T().TempDir()
+	jsonBytes, err := json.Marshal(encoded)
+	s.NoError(err)
+	jsonPath := filepath.Join(dir, "group.json")
+	s.NoError(ioutil.WriteFile(jsonPath, jsonBytes, 0600))
+	csvPath := filepath.Join(dir, "group.csv")
+	s.NoError(ioutil.WriteFile(csvPath, []byte(strings.Join(encoded, "\n")+"\n"), 0600))
+
+	for _, path := range []string{jsonPath, csvPath} {
+		rln, err := NewRLN(s.parameters)
+		s.NoError(err)
+
+		group, err := LoadGroupFile(rln, path, nil)
+		s.NoError(err)
+
+		root, err := group.Root()
+		s.NoError(err)
+		s.Equal(STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+
+		_, ok := group.OwnIndex()
+		s.False(ok)
+		s.Equal(path, group.Path())
+
+		s.NoError(rln.Close())
+	}
+
+	// the members appended to the file are inserted by Reload
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	s.NoError(ioutil.WriteFile(csvPath, []byte(strings.Join(encoded[:50], "\n")+"\n"), 0600))
+	group, err := LoadGroupFile(rln, csvPath, nil)
+	s.NoError(err)
+	s.NoError(group.Reload())
+	s.Equal(MembershipIndex(50), rln.NextIndex())
+
+	s.NoError(ioutil.WriteFile(csvPath, []byte(strings.Join(encoded, "\n")+"\n"), 0600))
+	s.NoError(group.Reload())
+	root, err := group.Root()
+	s.NoError(err)
+	s.Equal(STATIC_GROUP_MERKLE_ROOT, hex.EncodeToString(root[:]))
+	index, ok := group.IndexOf(commitments[70])
+	s.True(ok)
+	s.Equal(MembershipIndex(70), index)
+
+	// the members already loaded can not be changed or removed
+	s.NoError(ioutil.WriteFile(csvPath, []byte(strings.Join(encoded[1:], "\n")+"\n"), 0600))
+	s.Error(group.Reload())
+	s.NoError(ioutil.WriteFile(csvPath, []byte(strings.Join(encoded[:10], "\n")+"\n"), 0600))
+	s.Error(group.Reload())
+	s.NoError(rln.Close())
+
+	// a commitment listed twice is rejected
+	rln, err = NewRLN(s.parameters)
+	s.NoError(err)
+	s.NoError(ioutil.WriteFile(csvPath, []byte(strings.Join(append(encoded[:3:3], encoded[0]), "\n")+"\n"), 0600))
+	_, err = LoadGroupFile(rln, csvPath, nil)
+	s.ErrorIs(err, ErrDuplicateMember)
+	s.NoError(rln.Close())
+
+	rln, err = NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	badPath := filepath.Join(dir, "bad.csv")
+	s.NoError(ioutil.WriteFile(badPath, []byte("abcd\n"), 0600))
+	_, err = LoadGroupFile(rln, badPath, nil)
+	s.Error(err)
+
+	_, err = LoadGroupFile(rln, filepath.Join(dir, "group.txt"), nil)
+	s.Error(err)
+}
+
+func (s *RLNSuite) TestDynamicGroup() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	groupKeyPairs, commitments := staticGroupCommitments(s)
+
+	source := NewMemoryEventSource()
+	group := NewDynamicGroup(rln, &groupKeyPairs[5])
+
+	done := make(chan error)
+	go func() {
+		done <- group.Sync(context.Background(), source)
+	}()
+
+	for _, c := range commitments {
+		source.Register(c)
+	}
+	source.Remove(7)
+	source.Close()
+	s.NoError(<-done)
+
+	// the same group built from a static list
+	expected, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer expected.Close()
+	s.True(expected.AddAll(commitments))
+	s.True(expected.DeleteMember(7))
+	expectedRoot, err := expected.GetMerkleRoot()
+	s.NoError(err)
+
+	root, err := group.Root()
+	s.NoError(err)
+	s.Equal(expectedRoot, root)
+
+	index, ok := group.OwnIndex()
+	s.True(ok)
+	s.Equal(MembershipIndex(5), index)
+
+	_, ok = group.IndexOf(commitments[7])
+	s.False(ok)
+	s.Equal(uint64(STATIC_GROUP_SIZE+1), group.Applied())
+
+	// members must be registered in order, and only once
+	s.Error(group.Apply(MemberRegistered{Index: STATIC_GROUP_SIZE + 1, Commitment: commitments[7]}))
+	s.ErrorIs(group.Apply(MemberRegistered{Index: STATIC_GROUP_SIZE, Commitment: commitments[0]}), ErrDuplicateMember)
+	s.Equal(uint64(STATIC_GROUP_SIZE+1), group.Applied())
+	s.NoError(group.Apply(MemberRegistered{Index: STATIC_GROUP_SIZE, Commitment: commitments[7]}))
+	s.Equal(uint64(STATIC_GROUP_SIZE+2), group.Applied())
+	index, ok = group.IndexOf(commitments[7])
+	s.True(ok)
+	s.Equal(MembershipIndex(STATIC_GROUP_SIZE), index)
+}
+
+func (s *RLNSuite) TestDynamicGroupResume() {
+	_, commitments := staticGroupCommitments(s)
+
+	// the source of the first sync only holds the first events of the group
+	first := NewMemoryEventSource()
+	for _, c := range commitments[:50] {
+		first.Register(c)
+	}
+	first.Remove(3)
+	first.Close()
+
+	full := NewMemoryEventSource()
+	for _, c := range commitments[:50] {
+		full.Register(c)
+	}
+	full.Remove(3)
+	for _, c := range commitments[50:] {
+		full.Register(c)
+	}
+	full.Remove(60)
+	full.Close()
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	group := NewDynamicGroup(rln, nil)
+	s.NoError(group.Sync(context.Background(), first))
+	s.Equal(uint64(51), group.Applied())
+
+	var state bytes.Buffer
+	s.NoError(group.Save(&state))
+
+	restoredRLN, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer restoredRLN.Close()
+
+	restored, err := LoadDynamicGroup(restoredRLN, &state, nil)
+	s.NoError(err)
+	s.Equal(uint64(51), restored.Applied())
+	_, ok := restored.IndexOf(commitments[3])
+	s.False(ok)
+	index, ok := restored.IndexOf(commitments[49])
+	s.True(ok)
+	s.Equal(MembershipIndex(49), index)
+
+	// the restored group only applies the events it did not apply yet
+	s.NoError(restored.Sync(context.Background(), full))
+	s.Equal(uint64(STATIC_GROUP_SIZE+2), restored.Applied())
+
+	expected, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer expected.Close()
+	s.True(expected.AddAll(commitments))
+	s.True(expected.DeleteMember(3))
+	s.True(expected.DeleteMember(60))
+	expectedRoot, err := expected.GetMerkleRoot()
+	s.NoError(err)
+
+	root, err := restored.Root()
+	s.NoError(err)
+	s.Equal(expectedRoot, root)
+
+	// a sync of an up to date group applies nothing
+	s.NoError(restored.Sync(context.Background(), full))
+	s.Equal(uint64(STATIC_GROUP_SIZE+2), restored.Applied())
+
+	_, err = LoadDynamicGroup(restoredRLN, bytes.NewReader([]byte{1, 2}), nil)
+	s.ErrorIs(err, ErrInvalidSnapshot)
+}
+
+func (s *RLNSuite) TestMemoryEventSource() {
+	source := NewMemoryEventSource()
+	s.Equal(MembershipIndex(0), source.Register(IDCommitment{1}))
+	s.Equal(MembershipIndex(1), source.Register(IDCommitment{2}))
+	source.Remove(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := source.Subscribe(ctx, 0)
+	s.NoError(err)
+
+	s.Equal(MemberRegistered{Index: 0, Commitment: IDCommitment{1}}, <-events)
+	s.Equal(MemberRegistered{Index: 1, Commitment: IDCommitment{2}}, <-events)
+	s.Equal(MemberRemoved{Index: 0}, <-events)
+
+	// events emitted after the subscription are delivered as well
+	source.Register(IDCommitment{3})
+	s.Equal(MemberRegistered{Index: 2, Commitment: IDCommitment{3}}, <-events)
+
+	// a cancelled subscription is closed
+	cancel()
+	_, ok := <-events
+	s.False(ok)
+
+	// a closed source delivers the past events and closes the subscription
+	source.Close()
+	events, err = source.Subscribe(context.Background(), 0)
+	s.NoError(err)
+	n := 0
+	for range events {
+		n++
+	}
+	s.Equal(4, n)
+
+	// a subscription starts at the requested event
+	events, err = source.Subscribe(context.Background(), 2)
+	s.NoError(err)
+	s.Equal(MemberRemoved{Index: 0}, <-events)
+	s.Equal(MemberRegistered{Index: 2, Commitment: IDCommitment{3}}, <-events)
+	_, ok = <-events
+	s.False(ok)
+
+	events, err = source.Subscribe(context.Background(), 10)
+	s.NoError(err)
+	_, ok = <-events
+	s.False(ok)
+}
+
+func (s *RLNSuite) TestRootHistory() {
+	history := NewRootHistory(3)
+	s.Equal(3, history.Size())
+	s.Empty(history.Roots())
+
+	roots := []MerkleNode{{1}, {2}, {3}, {4}}
+	for _, root := range roots {
+		history.Add(root)
+		// adding the latest root again is a no-op
+		history.Add(root)
+	}
+
+	s.Equal(roots[1:], history.Roots())
+	s.False(history.Contains(roots[0]))
+	s.True(history.Contains(roots[1]))
+	s.True(history.Contains(roots[3]))
+
+	// an older root that comes back counts as a new one
+	history.Add(roots[1])
+	s.Equal([]MerkleNode{{3}, {4}, {2}}, history.Roots())
+
+	history.SetSize(1)
+	s.Equal([]MerkleNode{{2}}, history.Roots())
+
+	history.SetSize(0)
+	s.Equal(1, history.Size())
+}
+
+func (s *RLNSuite) TestValidateProof() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	memKeys, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	s.True(rln.InsertMember(memKeys.IDCommitment))
+
+	msg := []byte("Hello")
+	proof, err := rln.GenerateProof(msg, *memKeys, MembershipIndex(0), ToEpoch(1))
+	s.NoError(err)
+
+	verified, err := rln.ValidateProof(msg, *proof)
+	s.NoError(err)
+	s.True(verified)
+
+	// the proof stays valid while its root is one of the last roots
+	rln.SetRootWindowSize(3)
+	for i := 0; i < 2; i++ {
+		keys, err := rln.MembershipKeyGen()
+		s.NoError(err)
+		s.True(rln.InsertMember(keys.IDCommitment))
+	}
+
+	s.Len(rln.ValidRoots(), 3)
+	verified, err = rln.ValidateProof(msg, *proof)
+	s.NoError(err)
+	s.True(verified)
+
+	// the root leaves the window with the next change
+	s.True(rln.DeleteMember(2))
+	verified, err = rln.ValidateProof(msg, *proof)
+	s.ErrorIs(err, ErrUnknownRoot)
+	s.False(verified)
+
+	// the accepted roots can be provided by the caller too
+	verified, err = rln.VerifyWithRoots(msg, *proof, []MerkleNode{proof.MerkleRoot})
+	s.NoError(err)
+	s.True(verified)
+
+	verified, err = rln.VerifyWithRoots([]byte("Bye"), *proof, []MerkleNode{proof.MerkleRoot})
+	s.NoError(err)
+	s.False(verified)
+
+	_, err = rln.VerifyWithRoots(msg, *proof, nil)
+	s.ErrorIs(err, ErrUnknownRoot)
+
+	// a closed instance reports that it is closed, whatever the root
+	s.NoError(rln.Close())
+	_, err = rln.ValidateProof(msg, *proof)
+	s.ErrorIs(err, ErrClosed)
+}
+
+func (s *RLNSuite) TestValidator() {
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	memKeys, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	s.True(rln.InsertMember(memKeys.IDCommitment))
+
+	validator := NewValidator(rln, ValidatorConfig{MaxEpochGap: 2, ClockSkew: 5 * time.Second})
+
+	now := ToEpoch(1000).Time()
+	epoch := CalcEpoch(now)
+
+	msg := []byte("Hello")
+	proof, err := rln.GenerateProof(msg, *memKeys, MembershipIndex(0), epoch)
+	s.NoError(err)
+
+	verdict, err := validator.Validate(msg, *proof, now)
+	s.NoError(err)
+	s.Equal(Verdict{Kind: VerdictValid}, verdict)
+
+	// relaying the same message again is not spam
+	verdict, err = validator.Validate(msg, *proof, now)
+	s.NoError(err)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonDuplicate, verdict.Reason)
+
+	// the proof does not match the message
+	verdict, err = validator.Validate([]byte("Bye"), *proof, now)
+	s.NoError(err)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonBadProof, verdict.Reason)
+
+	// the epoch must be within the gap, extended by the clock skew
+	verdict, err = validator.Validate(msg, *proof, now.Add(2*time.Duration(EPOCH_UNIT_SECONDS)*time.Second))
+	s.NoError(err)
+	s.Equal(ReasonDuplicate, verdict.Reason)
+	verdict, err = validator.Validate(msg, *proof, now.Add(3*time.Duration(EPOCH_UNIT_SECONDS)*time.Second))
+	s.NoError(err)
+	s.Equal(ReasonDuplicate, verdict.Reason)
+	verdict, err = validator.Validate(msg, *proof, now.Add(4*time.Duration(EPOCH_UNIT_SECONDS)*time.Second))
+	s.NoError(err)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonBadEpoch, verdict.Reason)
+	verdict, err = validator.Validate(msg, *proof, now.Add(-4*time.Duration(EPOCH_UNIT_SECONDS)*time.Second))
+	s.NoError(err)
+	s.Equal(ReasonBadEpoch, verdict.Reason)
+
+	// shares that are not field elements
+	malformed := *proof
+	for i := range malformed.ShareY {
+		malformed.ShareY[i] = 0xff
+	}
+	verdict, err = validator.Validate(msg, malformed, now)
+	s.NoError(err)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonMalformed, verdict.Reason)
+
+	// the high bytes of the epoch are not part of the window, an epoch differing from the
+	// current one in them is rejected, otherwise the member could publish once per such epoch
+	highEpoch := epoch
+	highEpoch[16] = 1
+	highProof, err := rln.GenerateProof([]byte("Other"), *memKeys, MembershipIndex(0), highEpoch)
+	s.NoError(err)
+	verdict, err = validator.Validate([]byte("Other"), *highProof, now)
+	s.NoError(err)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonBadEpoch, verdict.Reason)
+
+	reused := *proof
+	reused.Epoch[16] = 1
+	verdict, err = validator.Validate(msg, reused, now)
+	s.NoError(err)
+	s.Equal(ReasonBadEpoch, verdict.Reason)
+
+	// a second message in the same epoch exposes the identity key of the member
+	msg2 := []byte("World")
+	proof2, err := rln.GenerateProof(msg2, *memKeys, MembershipIndex(0), epoch)
+	s.NoError(err)
+
+	verdict, err = validator.Validate(msg2, *proof2, now)
+	s.NoError(err)
+	s.Equal(VerdictSpam, verdict.Kind)
+	s.Equal(ReasonNone, verdict.Reason)
+	s.NotNil(verdict.Evidence)
+	s.Equal(epoch, verdict.Evidence.Epoch)
+	s.Equal(proof.ExtractMetadata(), verdict.Evidence.First)
+	s.Equal(proof2.ExtractMetadata(), verdict.Evidence.Second)
+	s.Equal(*memKeys, verdict.Evidence.Keypair)
+
+	// once the member is removed, the proofs are generated against an unknown root
+	s.True(rln.DeleteMember(0))
+	rln.SetRootWindowSize(1)
+	verdict, err = validator.Validate(msg, *proof, now)
+	s.NoError(err)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonUnknownRoot, verdict.Reason)
+
+	// a message that could not be validated is not valid
+	s.NoError(rln.Close())
+	verdict, err = validator.Validate(msg2, *proof2, now)
+	s.ErrorIs(err, ErrClosed)
+	s.Equal(VerdictInvalid, verdict.Kind)
+	s.Equal(ReasonError, verdict.Reason)
+	s.Equal(VerdictUnknown, Verdict{}.Kind)
+}
+
+// residentMemory returns the resident set size of the process in bytes, which unlike
+// runtime.MemStats also accounts for the memory allocated by the rln lib
+func residentMemory() (uint64, bool) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, false
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return pages * uint64(os.Getpagesize()), true
+}
+
+func (s *RLNSuite) TestMemoryIsStableOnProofCycles() {
+	// thousands of proofs take a long time to generate, by default a proof is only generated every
+	// thousand cycles and the leaks are detected on the hashes and roots, which use the same buffers.
+	// Use `make test-stress` to generate a proof on every cycle
+	cycles, proofEvery := 20000, 1000
+	if os.Getenv("RLN_STRESS_TEST") != "" {
+		cycles, proofEvery = 3000, 1
+	}
+
+	rln, err := NewRLN(s.parameters)
+	s.NoError(err)
+	defer rln.Close()
+
+	memKeys, err := rln.MembershipKeyGen()
+	s.NoError(err)
+	s.True(rln.InsertMember(memKeys.IDCommitment))
+
+	msg := []byte("Hello")
+	cycle := func(i int) {
+		if i%proofEvery == 0 {
+			proof, err := rln.GenerateProof(msg, *memKeys, MembershipIndex(0), GetCurrentEpoch())
+			s.NoError(err)
+			s.True(rln.Verify(msg, *proof))
+		}
+
+		_, err = rln.GetMerkleRoot()
+		s.NoError(err)
+
+		_, err = rln.Hash(msg)
+		s.NoError(err)
+	}
+
+	// warm up so that the allocators reach a steady state
+	for i := 0; i < 100; i++ {
+		cycle(i)
+	}
+
+	runtime.GC()
+	before, ok := residentMemory()
+	if !ok {
+		s.T().Skip("resident memory can not be measured on this platform")
+	}
+
+	for i := 0; i < cycles; i++ {
+		cycle(i)
+	}
+
+	runtime.GC()
+	after, _ := residentMemory()
+
+	// leaking the buffers exchanged with the rln lib costs at least 64 bytes per cycle, and more
+	// than 500 bytes per cycle with a proof
+	var growth uint64
+	if after > before {
+		growth = after - before
+	}
+	s.Less(growth, uint64(512*1024), "memory grew by %d bytes after %d cycles", growth, cycles)
+}
+
+// benchmarkCommitments returns n distinct identity commitments
+func benchmarkCommitments(n int) []IDCommitment {
+	commitments := make([]IDCommitment, n)
+	for i := range commitments {
+		commitments[i] = poseidon.ToBytes(fr.NewElement(uint64(i + 1)))
+	}
+	return commitments
+}
+
+func BenchmarkInsertMembers(b *testing.B) {
+	params, err := ioutil.ReadFile("./testdata/parameters.key")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{1000, 10000, 100000} {
+		commitments := benchmarkCommitments(n)
+
+		run := func(b *testing.B, insert func(rln *RLN) error) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				rln, err := NewRLN(params)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err := insert(rln); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				rln.Close()
+				b.StartTimer()
+			}
+		}
+
+		// both append the members one by one
+		b.Run("InsertMembers/"+strconv.Itoa(n), func(b *testing.B) {
+			run(b, func(rln *RLN) error {
+				return rln.InsertMembers(0, commitments)
+			})
+		})
+
+		b.Run("AddAll/"+strconv.Itoa(n), func(b *testing.B) {
+			run(b, func(rln *RLN) error {
+				return rln.InsertAll(commitments)
+			})
+		})
+	}
+}
+
+func BenchmarkVerifyBatch(b *testing.B) {
+	params, err := ioutil.ReadFile("./testdata/parameters.key")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	rln, err := NewRLN(params)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer rln.Close()
+
+	keys, err := toMembershipKeyPairs(STATIC_GROUP_KEYS)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := rln.Insert(key.IDCommitment); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// generating proofs is slow, the batches repeat a few distinct proofs
+	var proofs []VerifyItem
+	for i := 0; i < 8; i++ {
+		msg := []byte(strconv.Itoa(i))
+		proof, err := rln.GenerateProof(msg, keys[i], MembershipIndex(i), ToEpoch(1))
+		if err != nil {
+			b.Fatal(err)
+		}
+		proofs = append(proofs, VerifyItem{Data: msg, Proof: *proof})
+	}
+
+	for _, n := range []int{10, 100, 1000} {
+		items := make([]VerifyItem, n)
+		for i := range items {
+			items[i] = proofs[i%len(proofs)]
+		}
+
+		b.Run("VerifyBatch/"+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := rln.VerifyBatch(items); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("Verify/"+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, item := range items {
+					if !rln.Verify(item.Data, item.Proof) {
+						b.Fatal("invalid proof")
+					}
+				}
+			}
+		})
+	}
 }
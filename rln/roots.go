@@ -0,0 +1,85 @@
+package rln
+
+import "sync"
+
+// ACCEPTABLE_ROOT_WINDOW_SIZE is the default number of recent Merkle tree roots a proof can be generated against.
+// Accepting past roots lets the proofs generated right before a membership change be verified once it is applied
+const ACCEPTABLE_ROOT_WINDOW_SIZE = 5
+
+// RootHistory is a bounded list of the most recent Merkle tree roots. It is safe for concurrent use
+type RootHistory struct {
+	mu    sync.RWMutex
+	size  int
+	roots []MerkleNode
+}
+
+// NewRootHistory creates an empty history that keeps up to `size` roots
+func NewRootHistory(size int) *RootHistory {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &RootHistory{size: size}
+}
+
+// Add records a root, evicting the oldest one if the history is full. A root equal to
+// the latest one is not recorded twice
+func (h *RootHistory) Add(root MerkleNode) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.roots) != 0 && h.roots[len(h.roots)-1] == root {
+		return
+	}
+
+	h.roots = append(h.roots, root)
+	h.evict()
+}
+
+// Contains checks whether the root is one of the recorded roots
+func (h *RootHistory) Contains(root MerkleNode) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, r := range h.roots {
+		if r == root {
+			return true
+		}
+	}
+	return false
+}
+
+// Roots returns the recorded roots, from the oldest to the latest
+func (h *RootHistory) Roots() []MerkleNode {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return append([]MerkleNode(nil), h.roots...)
+}
+
+// Size returns the maximum number of roots kept
+func (h *RootHistory) Size() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.size
+}
+
+// SetSize changes the maximum number of roots kept, evicting the oldest roots if needed
+func (h *RootHistory) SetSize(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if size <= 0 {
+		size = 1
+	}
+
+	h.size = size
+	h.evict()
+}
+
+func (h *RootHistory) evict() {
+	if len(h.roots) > h.size {
+		h.roots = append([]MerkleNode(nil), h.roots[len(h.roots)-h.size:]...)
+	}
+}
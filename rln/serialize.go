@@ -1,6 +1,18 @@
 package rln
 
-import "encoding/binary"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RateLimitProofSize is the size in bytes of the binary encoding of a RateLimitProof
+// |proof<256>|root<32>|epoch<32>|share_x<32>|share_y<32>|nullifier<32>|
+const RateLimitProofSize = 416
 
 // serialize converts a RateLimitProof and the data to a byte seq
 // this conversion is used in the proofGen function
@@ -25,14 +37,128 @@ func serialize(idKey IDKey, memIndex MembershipIndex, epoch Epoch, msg []byte) [
 // the order of serialization is based on https://github.com/kilic/rln/blob/7ac74183f8b69b399e3bc96c1ae8ab61c026dc43/src/public.rs#L205
 // [ proof<256>| root<32>| epoch<32>| share_x<32>| share_y<32>| nullifier<32> | signal_len<8> | signal<var> ]
 func (r RateLimitProof) serialize(data []byte) []byte {
+	proofBytes, _ := r.MarshalBinary()
+	return append(proofBytes, appendLength(data)...)
+}
 
-	lenPrefMsg := appendLength(data)
-	proofBytes := append(r.Proof[:], r.MerkleRoot[:]...)
+// MarshalBinary encodes the proof as |proof<256>|root<32>|epoch<32>|share_x<32>|share_y<32>|nullifier<32>|
+// which is the layout produced by the rln lib
+func (r RateLimitProof) MarshalBinary() ([]byte, error) {
+	proofBytes := make([]byte, 0, RateLimitProofSize)
+	proofBytes = append(proofBytes, r.Proof[:]...)
+	proofBytes = append(proofBytes, r.MerkleRoot[:]...)
 	proofBytes = append(proofBytes, r.Epoch[:]...)
 	proofBytes = append(proofBytes, r.ShareX[:]...)
 	proofBytes = append(proofBytes, r.ShareY[:]...)
 	proofBytes = append(proofBytes, r.Nullifier[:]...)
-	proofBytes = append(proofBytes, lenPrefMsg...)
+	return proofBytes, nil
+}
+
+// UnmarshalBinary decodes a proof encoded with MarshalBinary. The input must be exactly
+// RateLimitProofSize bytes long
+func (r *RateLimitProof) UnmarshalBinary(data []byte) error {
+	if len(data) != RateLimitProofSize {
+		return newError("UnmarshalBinary", ErrInvalidProof, fmt.Errorf("expected %d bytes, got %d", RateLimitProofSize, len(data)))
+	}
+
+	proofOffset := len(r.Proof)
+	rootOffset := proofOffset + 32
+	epochOffset := rootOffset + 32
+	shareXOffset := epochOffset + 32
+	shareYOffset := shareXOffset + 32
+	nullifierOffset := shareYOffset + 32
+
+	copy(r.Proof[:], data[0:proofOffset])
+	copy(r.MerkleRoot[:], data[proofOffset:rootOffset])
+	copy(r.Epoch[:], data[rootOffset:epochOffset])
+	copy(r.ShareX[:], data[epochOffset:shareXOffset])
+	copy(r.ShareY[:], data[shareXOffset:shareYOffset])
+	copy(r.Nullifier[:], data[shareYOffset:nullifierOffset])
+
+	return nil
+}
+
+// MarshalText encodes the binary representation of the proof in hexadecimal
+func (r RateLimitProof) MarshalText() ([]byte, error) {
+	proofBytes, _ := r.MarshalBinary()
+	text := make([]byte, hex.EncodedLen(len(proofBytes)))
+	hex.Encode(text, proofBytes)
+	return text, nil
+}
+
+// UnmarshalText decodes a proof encoded with MarshalText
+func (r *RateLimitProof) UnmarshalText(text []byte) error {
+	if len(text) != hex.EncodedLen(RateLimitProofSize) {
+		return newError("UnmarshalText", ErrInvalidProof, fmt.Errorf("expected %d hex characters, got %d", hex.EncodedLen(RateLimitProofSize), len(text)))
+	}
+
+	proofBytes := make([]byte, RateLimitProofSize)
+	if _, err := hex.Decode(proofBytes, text); err != nil {
+		return newError("UnmarshalText", ErrInvalidProof, err)
+	}
+
+	return r.UnmarshalBinary(proofBytes)
+}
+
+// rateLimitProofJSON is the JSON representation of a RateLimitProof, every field is hex encoded
+type rateLimitProofJSON struct {
+	Proof      string `json:"proof"`
+	MerkleRoot string `json:"merkleRoot"`
+	Epoch      string `json:"epoch"`
+	ShareX     string `json:"shareX"`
+	ShareY     string `json:"shareY"`
+	Nullifier  string `json:"nullifier"`
+}
+
+// MarshalJSON encodes the proof as a JSON object with the fields in hexadecimal
+func (r RateLimitProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rateLimitProofJSON{
+		Proof:      hex.EncodeToString(r.Proof[:]),
+		MerkleRoot: hex.EncodeToString(r.MerkleRoot[:]),
+		Epoch:      hex.EncodeToString(r.Epoch[:]),
+		ShareX:     hex.EncodeToString(r.ShareX[:]),
+		ShareY:     hex.EncodeToString(r.ShareY[:]),
+		Nullifier:  hex.EncodeToString(r.Nullifier[:]),
+	})
+}
+
+// UnmarshalJSON decodes a proof encoded with MarshalJSON. Every field must be present
+// and hold a value of the right size, and no other field is accepted
+func (r *RateLimitProof) UnmarshalJSON(data []byte) error {
+	var aux rateLimitProofJSON
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&aux); err != nil {
+		return newError("UnmarshalJSON", ErrInvalidProof, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return newError("UnmarshalJSON", ErrInvalidProof, errors.New("unexpected data after the proof"))
+	}
+
+	var proof RateLimitProof
+	fields := []struct {
+		name  string
+		value string
+		dst   []byte
+	}{
+		{"proof", aux.Proof, proof.Proof[:]},
+		{"merkleRoot", aux.MerkleRoot, proof.MerkleRoot[:]},
+		{"epoch", aux.Epoch, proof.Epoch[:]},
+		{"shareX", aux.ShareX, proof.ShareX[:]},
+		{"shareY", aux.ShareY, proof.ShareY[:]},
+		{"nullifier", aux.Nullifier, proof.Nullifier[:]},
+	}
+
+	for _, f := range fields {
+		if len(f.value) != hex.EncodedLen(len(f.dst)) {
+			return newError("UnmarshalJSON", ErrInvalidProof, fmt.Errorf("field %s must be %d bytes long", f.name, len(f.dst)))
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.value)); err != nil {
+			return newError("UnmarshalJSON", ErrInvalidProof, fmt.Errorf("field %s: %w", f.name, err))
+		}
+	}
+
+	*r = proof
 
-	return proofBytes
+	return nil
 }
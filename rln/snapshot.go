@@ -0,0 +1,296 @@
+package rln
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/waku-org/go-rln/rln/merkle"
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+// SNAPSHOT_VERSION is the version of the format written by SaveTree
+const SNAPSHOT_VERSION = uint32(1)
+
+var snapshotMagic = [4]byte{'R', 'L', 'N', 'T'}
+
+// A snapshot holds, in little endian:
+//
+//	magic<4> | version<4> | depth<4> | next_index<8> |
+//	leaf_count<8> | (index<8> | leaf<32>)* |
+//	deleted_count<8> | index<8>* |
+//	root<32> | sha256<32>
+//
+// The leaves are the non zero leaves, the deleted slots are the zero leaves below the next index,
+// and the checksum covers everything that precedes it. Indexes are sorted in ascending order
+
+// SaveTree writes a snapshot of the Merkle tree, holding its leaves, next index and deleted slots
+func (r *RLN) SaveTree(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.backend == nil {
+		return newError("SaveTree", ErrClosed, nil)
+	}
+
+	var leaves, deleted []MembershipIndex
+	for index := uint(0); index < r.tree.NextIndex(); index++ {
+		leaf, err := r.tree.Leaf(index)
+		if err != nil {
+			return newError("SaveTree", ErrInvalidIndex, err)
+		}
+		if leaf == (IDCommitment{}) {
+			deleted = append(deleted, index)
+		} else {
+			leaves = append(leaves, index)
+		}
+	}
+
+	checksum := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	write := func(values ...interface{}) {
+		for _, v := range values {
+			// writes to a bufio.Writer only fail once the underlying writer failed, the error is reported by Flush
+			_ = binary.Write(out, binary.LittleEndian, v)
+		}
+	}
+
+	write(snapshotMagic, SNAPSHOT_VERSION, uint32(r.depth), uint64(r.tree.NextIndex()))
+
+	write(uint64(len(leaves)))
+	for _, index := range leaves {
+		leaf, _ := r.tree.Leaf(index)
+		write(uint64(index), leaf)
+	}
+
+	write(uint64(len(deleted)))
+	for _, index := range deleted {
+		write(uint64(index))
+	}
+
+	write(r.tree.Root())
+
+	if err := out.Flush(); err != nil {
+		return newError("SaveTree", ErrInvalidSnapshot, err)
+	}
+
+	if _, err := w.Write(checksum.Sum(nil)); err != nil {
+		return newError("SaveTree", ErrInvalidSnapshot, err)
+	}
+
+	return nil
+}
+
+// LoadTree loads the Merkle tree of a snapshot written by SaveTree into an empty tree. The snapshot
+// is fully checked before the tree is modified, and the roots accepted by ValidateProof are reset
+// to the root of the snapshot. The rln lib can not empty its tree, so loading a snapshot into a
+// tree that is not empty is rejected with an error of kind ErrUnsupported
+func (r *RLN) LoadTree(snapshot io.Reader) error {
+	tree, err := readSnapshot(snapshot, r.depth)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backend == nil {
+		return newError("LoadTree", ErrClosed, nil)
+	}
+
+	if r.tree.NextIndex() != 0 {
+		return newError("LoadTree", ErrUnsupported, errors.New("a snapshot can only be loaded into an empty tree"))
+	}
+
+	for index := uint(0); index < tree.NextIndex(); index++ {
+		leaf, _ := tree.Leaf(index)
+		if err := r.backend.AppendLeaf(leaf); err != nil {
+			// the mirror keeps the leaves accepted by the backend
+			leaves := make([]IDCommitment, index)
+			for i := range leaves {
+				leaves[i], _ = tree.Leaf(uint(i))
+			}
+			if setErr := r.tree.SetLeaves(0, leaves); setErr != nil {
+				return newError("LoadTree", ErrFFI, setErr)
+			}
+			r.roots.Add(r.tree.Root())
+			return newError("LoadTree", ErrFFI, err)
+		}
+	}
+
+	r.tree = tree
+	r.roots = NewRootHistory(r.roots.Size())
+	r.roots.Add(tree.Root())
+
+	return nil
+}
+
+// NewRLNFromSnapshot creates an instance of RLN holding the Merkle tree of a snapshot written by SaveTree
+func NewRLNFromSnapshot(params *Params, snapshot io.Reader) (*RLN, error) {
+	r, err := NewRLNWithParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.LoadTree(snapshot); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// snapshotReader decodes the fields of a snapshot, keeping the first error
+type snapshotReader struct {
+	r        io.Reader
+	checksum hash.Hash
+	err      error
+}
+
+func (s *snapshotReader) read(v interface{}) {
+	if s.err == nil {
+		s.err = binary.Read(io.TeeReader(s.r, s.checksum), binary.LittleEndian, v)
+	}
+}
+
+func (s *snapshotReader) uint64() uint64 {
+	var v uint64
+	s.read(&v)
+	return v
+}
+
+func corrupted(format string, args ...interface{}) error {
+	return newError("LoadTree", ErrInvalidSnapshot, fmt.Errorf(format, args...))
+}
+
+// readSnapshot decodes and checks a snapshot, and builds its tree
+func readSnapshot(snapshot io.Reader, depth int) (*merkle.Tree, error) {
+	s := &snapshotReader{r: bufio.NewReader(snapshot), checksum: sha256.New()}
+
+	var magic [4]byte
+	var version, snapshotDepth uint32
+	s.read(&magic)
+	s.read(&version)
+	if s.err != nil {
+		return nil, corrupted("could not read the header: %v", s.err)
+	}
+	if magic != snapshotMagic {
+		return nil, corrupted("not a tree snapshot")
+	}
+	if version != SNAPSHOT_VERSION {
+		return nil, newError("LoadTree", ErrUnsupportedSnapshot, fmt.Errorf("version %d, expected %d", version, SNAPSHOT_VERSION))
+	}
+
+	s.read(&snapshotDepth)
+	next := s.uint64()
+	if s.err != nil {
+		return nil, corrupted("could not read the header: %v", s.err)
+	}
+	// the depth is only compared once the checksum is verified, so that a corrupted depth is
+	// reported as a corrupted snapshot
+	if snapshotDepth == 0 || snapshotDepth >= 64 {
+		return nil, corrupted("invalid depth %d", snapshotDepth)
+	}
+	if next > uint64(1)<<snapshotDepth {
+		return nil, corrupted("next index %d is out of bounds", next)
+	}
+
+	// the slots are collected as they are read, so that a corrupted count does not allocate the whole tree
+	type slot struct {
+		index uint64
+		leaf  IDCommitment
+	}
+	var leaves []slot
+	var deleted []uint64
+
+	leafCount := s.uint64()
+	if s.err == nil && leafCount > next {
+		return nil, corrupted("%d leaves for a next index of %d", leafCount, next)
+	}
+	for i := uint64(0); i < leafCount && s.err == nil; i++ {
+		index := s.uint64()
+		var leaf IDCommitment
+		s.read(&leaf)
+		if s.err != nil {
+			break
+		}
+		if index >= next || (len(leaves) > 0 && index <= leaves[len(leaves)-1].index) {
+			return nil, corrupted("invalid leaf index %d", index)
+		}
+		if value, err := poseidon.FromBytes(leaf); err != nil || value.IsZero() {
+			return nil, corrupted("invalid leaf at index %d", index)
+		}
+		leaves = append(leaves, slot{index, leaf})
+	}
+
+	// every slot below the next index is either a leaf or a deleted slot
+	deletedCount := s.uint64()
+	if s.err == nil && leafCount+deletedCount != next {
+		return nil, corrupted("%d leaves and %d deleted slots for a next index of %d", leafCount, deletedCount, next)
+	}
+	for i := uint64(0); i < deletedCount && s.err == nil; i++ {
+		index := s.uint64()
+		if s.err != nil {
+			break
+		}
+		if index >= next || (len(deleted) > 0 && index <= deleted[len(deleted)-1]) {
+			return nil, corrupted("invalid deleted slot %d", index)
+		}
+		deleted = append(deleted, index)
+	}
+
+	var root MerkleNode
+	s.read(&root)
+	if s.err != nil {
+		return nil, corrupted("truncated snapshot: %v", s.err)
+	}
+
+	expected := s.checksum.Sum(nil)
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(s.r, checksum); err != nil {
+		return nil, corrupted("truncated snapshot: %v", err)
+	}
+	if !bytes.Equal(checksum, expected) {
+		return nil, corrupted("checksum mismatch")
+	}
+
+	if _, err := s.r.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		return nil, corrupted("unexpected data after the checksum")
+	}
+
+	if int(snapshotDepth) != depth {
+		return nil, newError("LoadTree", ErrInvalidParams, fmt.Errorf("the snapshot holds a tree of depth %d, not %d", snapshotDepth, depth))
+	}
+
+	// both lists are sorted and cover `next` slots, they are disjoint if they cover all the slots
+	dense := make([]IDCommitment, next)
+	covered := make([]bool, next)
+	for _, l := range leaves {
+		dense[l.index], covered[l.index] = l.leaf, true
+	}
+	for _, index := range deleted {
+		if covered[index] {
+			return nil, corrupted("slot %d is both a leaf and deleted", index)
+		}
+		covered[index] = true
+	}
+
+	tree, err := merkle.New(depth)
+	if err != nil {
+		return nil, newError("LoadTree", ErrInvalidParams, err)
+	}
+	if err := tree.SetLeaves(0, dense); err != nil {
+		return nil, corrupted("%v", err)
+	}
+	if tree.Root() != root {
+		return nil, corrupted("the leaves do not match the root")
+	}
+
+	return tree, nil
+}
@@ -3,14 +3,17 @@ package rln
 import (
 	"bytes"
 	"encoding/binary"
+	"sync"
 	"time"
+
+	"github.com/waku-org/go-rln/rln/keys"
 )
 
 // IDKey is an identity key as defined in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
-type IDKey = [32]byte
+type IDKey = keys.IDKey
 
 // IDCommintment is hash of identity key as defined in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
-type IDCommitment = [32]byte
+type IDCommitment = keys.IDCommitment
 
 // Each node of the Merkle tee is a Poseidon hash which is a 32 byte value
 type MerkleNode = [32]byte
@@ -21,15 +24,9 @@ type ZKSNARK = [256]byte
 
 // Custom data types defined for waku rln relay -------------------------
 
-type MembershipKeyPair = struct {
-	// user's identity key (a secret key) which is selected randomly
-	// see details in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
-	IDKey IDKey
-	// hash of user's identity key generated by
-	// Poseidon hash function implemented in rln lib
-	// more details in https://hackmd.io/tMTLMYmTR5eynw2lwK9n1w?view#Membership
-	IDCommitment IDCommitment
-}
+// MembershipKeyPair holds the identity key of a member and its identity commitment. It is defined
+// in the keys package, which can be used without cgo
+type MembershipKeyPair = keys.MembershipKeyPair
 
 type RateLimitProof struct {
 	// RateLimitProof holds the public inputs to rln circuit as
@@ -50,7 +47,7 @@ type RateLimitProof struct {
 	Nullifier Nullifier
 }
 
-type MembershipIndex = uint
+type MembershipIndex = keys.MembershipIndex
 
 type ProofMetadata struct {
 	Nullifier Nullifier
@@ -62,9 +59,22 @@ func (p ProofMetadata) Equals(p2 ProofMetadata) bool {
 	return bytes.Equal(p.Nullifier[:], p2.Nullifier[:]) && bytes.Equal(p.ShareX[:], p2.ShareX[:]) && bytes.Equal(p.ShareY[:], p2.ShareY[:])
 }
 
+// ExtractMetadata returns the ProofMetadata of a proof
+func (r RateLimitProof) ExtractMetadata() ProofMetadata {
+	return ProofMetadata{
+		Nullifier: r.Nullifier,
+		ShareX:    r.ShareX,
+		ShareY:    r.ShareY,
+	}
+}
+
 //  the current implementation of the rln lib only supports a circuit for Merkle tree with depth 32
 const MERKLE_TREE_DEPTH int = 20
 
+// MAX_MEMBERS_GAP is the number of empty leaves InsertMembers and SetMember can leave between
+// NextIndex and a new member. The rln lib appends the empty leaves one by one, with the tree locked
+const MAX_MEMBERS_GAP = 1 << 12
+
 // HASH_BIT_SIZE is the size of poseidon hash output in bits
 const HASH_BIT_SIZE = 256
 
@@ -186,6 +196,25 @@ func init() {
 	}
 }
 
+var staticGroup struct {
+	once  sync.Once
+	pairs []MembershipKeyPair
+	err   error
+}
+
+// StaticGroupKeyPairs returns the key pairs of STATIC_GROUP_KEYS. The pairs are decoded and
+// validated on the first call, and an error is returned if any of them is corrupted
+func StaticGroupKeyPairs() ([]MembershipKeyPair, error) {
+	staticGroup.once.Do(func() {
+		staticGroup.pairs, staticGroup.err = toMembershipKeyPairs(STATIC_GROUP_KEYS)
+	})
+	if staticGroup.err != nil {
+		return nil, staticGroup.err
+	}
+
+	return append([]MembershipKeyPair(nil), staticGroup.pairs...), nil
+}
+
 // STATIC_GROUP_MERKLE_ROOT is the root of the Merkle tree constructed from the STATIC_GROUP_KEYS above
 // only identity commitments are used for the Merkle tree construction
 // the root is created locally, using createMembershipList proc from waku_rln_relay_utils module, and the result is hardcoded in here
@@ -213,12 +242,12 @@ func (e Epoch) Uint64() uint64 {
 
 // CalcEpoch returns the corresponding rln `Epoch` value for a time.Time
 func CalcEpoch(t time.Time) Epoch {
-	return ToEpoch(uint64(t.Unix()) / EPOCH_UNIT_SECONDS)
+	return DefaultEpochConfig().At(t)
 }
 
 // GetCurrentEpoch gets the current rln Epoch time
 func GetCurrentEpoch() Epoch {
-	return CalcEpoch(time.Now())
+	return DefaultEpochConfig().Current()
 }
 
 // Diff returns the difference between the two rln `Epoch`s `e1` and `e2`
@@ -228,6 +257,7 @@ func Diff(e1, e2 Epoch) int64 {
 	return int64(epoch1) - int64(epoch2)
 }
 
+// Time returns the time at which the epoch starts
 func (e Epoch) Time() time.Time {
-	return time.Unix(int64(e.Uint64()*EPOCH_UNIT_SECONDS), 0)
+	return DefaultEpochConfig().Start(e)
 }
@@ -1,23 +1,38 @@
 package rln
 
-import "encoding/hex"
+import (
+	"encoding/hex"
+	"fmt"
+)
 
 func toMembershipKeyPairs(groupKeys [][]string) ([]MembershipKeyPair, error) {
 	// groupKeys is  sequence of membership key tuples in the form of (identity key, identity commitment) all in the hexadecimal format
 	// the toMembershipKeyPairs proc populates a sequence of MembershipKeyPairs using the supplied groupKeys
+	// each pair is checked, so that a corrupted group is rejected before its tree is built
 
 	groupKeyPairs := []MembershipKeyPair{}
-	for _, pair := range groupKeys {
+	for i, pair := range groupKeys {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("key pair %d: expected 2 values, got %d", i, len(pair))
+		}
 		idKey, err := hex.DecodeString(pair[0])
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("key pair %d: %w", i, err)
 		}
 		idCommitment, err := hex.DecodeString(pair[1])
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("key pair %d: %w", i, err)
+		}
+		if len(idKey) != 32 || len(idCommitment) != 32 {
+			return nil, fmt.Errorf("key pair %d: the identity key and commitment must be 32 bytes long", i)
+		}
+
+		keyPair := MembershipKeyPair{IDKey: IDKey(Bytes32(idKey)), IDCommitment: IDCommitment(Bytes32(idCommitment))}
+		if err := keyPair.Validate(); err != nil {
+			return nil, fmt.Errorf("key pair %d: %w", i, err)
 		}
 
-		groupKeyPairs = append(groupKeyPairs, MembershipKeyPair{IDKey: IDKey(Bytes32(idKey)), IDCommitment: IDCommitment(Bytes32(idCommitment))})
+		groupKeyPairs = append(groupKeyPairs, keyPair)
 	}
 
 	return groupKeyPairs, nil
@@ -0,0 +1,208 @@
+package rln
+
+import (
+	"errors"
+	"time"
+
+	"github.com/waku-org/go-rln/rln/poseidon"
+)
+
+// MAX_EPOCH_GAP is the default maximum difference between the epoch of a message and the current epoch
+const MAX_EPOCH_GAP = uint64(20)
+
+// VerdictKind is the outcome of the validation of a message. Its zero value is VerdictUnknown,
+// so that an empty Verdict is never mistaken for a valid one
+type VerdictKind int
+
+const (
+	// VerdictUnknown means that the message was not validated, it must not be relayed
+	VerdictUnknown VerdictKind = iota
+	// VerdictValid means that the message can be relayed
+	VerdictValid
+	// VerdictInvalid means that the message must be dropped, see InvalidReason
+	VerdictInvalid
+	// VerdictSpam means that the member exceeded the rate limit and can be slashed with the SpamEvidence
+	VerdictSpam
+)
+
+func (k VerdictKind) String() string {
+	switch k {
+	case VerdictValid:
+		return "valid"
+	case VerdictInvalid:
+		return "invalid"
+	case VerdictSpam:
+		return "spam"
+	case VerdictUnknown:
+		return "unknown"
+	default:
+		return "unknown"
+	}
+}
+
+// InvalidReason describes why a message is invalid
+type InvalidReason int
+
+const (
+	// ReasonNone is the reason of the verdicts that are not invalid
+	ReasonNone InvalidReason = iota
+	// ReasonMalformed means that the proof does not hold valid field elements
+	ReasonMalformed
+	// ReasonBadEpoch means that the epoch of the message is too far from the current epoch
+	ReasonBadEpoch
+	// ReasonUnknownRoot means that the proof was generated against a Merkle tree root that is not accepted
+	ReasonUnknownRoot
+	// ReasonBadProof means that the zkSNARK proof does not verify
+	ReasonBadProof
+	// ReasonDuplicate means that the message was already validated
+	ReasonDuplicate
+	// ReasonError means that the validation could not be performed, the error is returned along
+	// with the verdict
+	ReasonError
+)
+
+func (r InvalidReason) String() string {
+	switch r {
+	case ReasonNone:
+		return "none"
+	case ReasonMalformed:
+		return "malformed input"
+	case ReasonBadEpoch:
+		return "bad epoch"
+	case ReasonUnknownRoot:
+		return "unknown root"
+	case ReasonBadProof:
+		return "bad proof"
+	case ReasonDuplicate:
+		return "duplicate"
+	case ReasonError:
+		return "validation error"
+	default:
+		return "unknown"
+	}
+}
+
+// SpamEvidence holds the two proofs published by a member in the same epoch, and the
+// membership key pair recovered from them
+type SpamEvidence struct {
+	Epoch   Epoch
+	First   ProofMetadata
+	Second  ProofMetadata
+	Keypair MembershipKeyPair
+}
+
+// Verdict is the result of the validation of a message. Reason is only set for invalid
+// messages and Evidence for spam
+type Verdict struct {
+	Kind     VerdictKind
+	Reason   InvalidReason
+	Evidence *SpamEvidence
+}
+
+func invalid(reason InvalidReason) Verdict {
+	return Verdict{Kind: VerdictInvalid, Reason: reason}
+}
+
+// ValidatorConfig holds the rules applied by a Validator
+type ValidatorConfig struct {
+	// MaxEpochGap is the maximum difference between the epoch of a message and the current epoch
+	MaxEpochGap uint64
+	// ClockSkew is the tolerated difference between the local clock and the clock of the publishers
+	ClockSkew time.Duration
+	// Epochs describes the epochs the messages are published in
+	Epochs EpochConfig
+}
+
+// DefaultValidatorConfig returns the configuration used by the waku rln relay
+func DefaultValidatorConfig() ValidatorConfig {
+	return ValidatorConfig{
+		MaxEpochGap: MAX_EPOCH_GAP,
+		ClockSkew:   time.Duration(EPOCH_UNIT_SECONDS) * time.Second,
+		Epochs:      DefaultEpochConfig(),
+	}
+}
+
+// Validator checks the messages received by a node, combining the checks of the epoch, the Merkle tree root,
+// the zkSNARK proof and the nullifier of a message. It is safe for concurrent use
+type Validator struct {
+	rln       *RLN
+	config    ValidatorConfig
+	nullifier *NullifierLog
+}
+
+// NewValidator creates a validator that checks proofs against the Merkle tree of `rln`
+func NewValidator(rln *RLN, config ValidatorConfig) *Validator {
+	return &Validator{
+		rln:    rln,
+		config: config,
+		// the proofs of the epochs that are not accepted, in the past or the future, do not need to be kept
+		nullifier: NewNullifierLog(2*config.MaxEpochGap + 2*skewEpochs(config.ClockSkew, config.Epochs.unit()) + 1),
+	}
+}
+
+func skewEpochs(skew time.Duration, unit time.Duration) uint64 {
+	return uint64((skew + unit - 1) / unit)
+}
+
+// Validate checks a message received at `now`. An error is returned only when the
+// validation could not be performed, along with an invalid verdict of reason ReasonError
+func (v *Validator) Validate(msg []byte, proof RateLimitProof, now time.Time) (Verdict, error) {
+	for _, value := range [][32]byte{proof.MerkleRoot, proof.ShareX, proof.ShareY, proof.Nullifier} {
+		if _, err := poseidon.FromBytes(value); err != nil {
+			return invalid(ReasonMalformed), nil
+		}
+	}
+
+	// the window is checked on the epoch as a uint64, while the circuit and the nullifier log use
+	// its 32 bytes. An epoch with other high bytes would be a distinct epoch within the window
+	if proof.Epoch != ToEpoch(proof.Epoch.Uint64()) {
+		return invalid(ReasonBadEpoch), nil
+	}
+
+	// the epoch must be close to the current one, taking into account the clock skew
+	earliest := v.config.Epochs.At(now.Add(-v.config.ClockSkew)).Uint64()
+	latest := v.config.Epochs.At(now.Add(v.config.ClockSkew)).Uint64()
+	epoch := proof.Epoch.Uint64()
+	if epoch+v.config.MaxEpochGap < earliest || epoch > latest+v.config.MaxEpochGap {
+		return invalid(ReasonBadEpoch), nil
+	}
+
+	verified, err := v.rln.ValidateProof(msg, proof)
+	if errors.Is(err, ErrUnknownRoot) {
+		return invalid(ReasonUnknownRoot), nil
+	}
+	if err != nil {
+		return invalid(ReasonError), err
+	}
+	if !verified {
+		return invalid(ReasonBadProof), nil
+	}
+
+	// only verified proofs are logged, so that forged proofs can not frame a member
+	v.nullifier.Prune(v.config.Epochs.At(now))
+
+	metadata := proof.ExtractMetadata()
+	status, previous := v.nullifier.Add(proof.Epoch, metadata)
+	switch status {
+	case ProofDuplicate:
+		return invalid(ReasonDuplicate), nil
+	case ProofOutOfWindow:
+		return invalid(ReasonBadEpoch), nil
+	case ProofDoubleSignal:
+		keypair, err := RecoverMembershipKeyPair(previous, metadata)
+		if err != nil {
+			return invalid(ReasonError), err
+		}
+		return Verdict{
+			Kind: VerdictSpam,
+			Evidence: &SpamEvidence{
+				Epoch:   proof.Epoch,
+				First:   previous,
+				Second:  metadata,
+				Keypair: keypair,
+			},
+		}, nil
+	default:
+		return Verdict{Kind: VerdictValid}, nil
+	}
+}
@@ -0,0 +1,295 @@
+// Package wakupb converts rate limit proofs to and from the protobuf messages of 17/WAKU-RLN-RELAY,
+// where a RateLimitProof is carried by the rate_limit_proof field of a WakuMessage:
+//
+//	message RateLimitProof {
+//	  bytes proof = 1;
+//	  bytes merkle_root = 2;
+//	  bytes epoch = 3;
+//	  bytes share_x = 4;
+//	  bytes share_y = 5;
+//	  bytes nullifier = 6;
+//	}
+//
+//	message WakuMessage {
+//	  bytes payload = 1;
+//	  string contentTopic = 2;
+//	  uint32 version = 3;
+//	  sint64 timestamp = 10;
+//	  RateLimitProof rate_limit_proof = 21;
+//	  bool ephemeral = 31;
+//	}
+//
+// The messages are encoded with the protobuf wire format, fields are written in ascending order and
+// fields holding their default value are omitted. Unknown fields are skipped when decoding.
+//
+// The encoding is written by hand rather than generated with protoc-gen-go on purpose. Generated
+// types register their messages in the global registry of the protobuf module, and Waku nodes
+// such as go-waku already register their own generated WakuMessage and RateLimitProof, so a
+// second registration of the same names would conflict in their binaries. The protobuf module is
+// only used by the tests of this package, which check the encoding against its reference encoder
+package wakupb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/waku-org/go-rln/rln"
+)
+
+// ErrMalformed is returned when a message can not be decoded
+var ErrMalformed = errors.New("malformed protobuf message")
+
+// wire types of the protobuf encoding
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// field numbers of WakuMessage
+const (
+	payloadField        = 1
+	contentTopicField   = 2
+	versionField        = 3
+	timestampField      = 10
+	rateLimitProofField = 21
+	ephemeralField      = 31
+)
+
+// proofFields returns the fields of a RateLimitProof, indexed by their field number minus one
+func proofFields(p *rln.RateLimitProof) []struct {
+	name  string
+	value []byte
+} {
+	return []struct {
+		name  string
+		value []byte
+	}{
+		{"proof", p.Proof[:]},
+		{"merkle_root", p.MerkleRoot[:]},
+		{"epoch", p.Epoch[:]},
+		{"share_x", p.ShareX[:]},
+		{"share_y", p.ShareY[:]},
+		{"nullifier", p.Nullifier[:]},
+	}
+}
+
+// MarshalRateLimitProof encodes a proof as a RateLimitProof protobuf message
+func MarshalRateLimitProof(proof rln.RateLimitProof) []byte {
+	var b []byte
+	for i, f := range proofFields(&proof) {
+		b = appendBytes(b, i+1, f.value)
+	}
+	return b
+}
+
+// UnmarshalRateLimitProof decodes a RateLimitProof protobuf message. Every field must be present
+// and hold a value of the size used by the rln lib
+func UnmarshalRateLimitProof(b []byte) (rln.RateLimitProof, error) {
+	var proof rln.RateLimitProof
+	fields := proofFields(&proof)
+	found := make([]bool, len(fields))
+
+	err := parse(b, func(field int, wireType int, value []byte, _ uint64) error {
+		if field < 1 || field > len(fields) {
+			return nil
+		}
+		f := fields[field-1]
+		if wireType != wireBytes {
+			return fmt.Errorf("field %s has wire type %d", f.name, wireType)
+		}
+		if len(value) != len(f.value) {
+			return fmt.Errorf("field %s must be %d bytes long, got %d", f.name, len(f.value), len(value))
+		}
+		copy(f.value, value)
+		found[field-1] = true
+		return nil
+	})
+	if err != nil {
+		return rln.RateLimitProof{}, err
+	}
+
+	for i, f := range fields {
+		if !found[i] {
+			return rln.RateLimitProof{}, fmt.Errorf("%w: field %s is missing", ErrMalformed, f.name)
+		}
+	}
+
+	return proof, nil
+}
+
+// WakuMessage is the message relayed by 17/WAKU-RLN-RELAY
+type WakuMessage struct {
+	Payload        []byte
+	ContentTopic   string
+	Version        uint32
+	Timestamp      int64
+	RateLimitProof *rln.RateLimitProof
+	Ephemeral      bool
+}
+
+// Signal returns the signal the proof of a message is generated for, see Signal
+func (m *WakuMessage) Signal() []byte {
+	return Signal(m.Payload, m.ContentTopic)
+}
+
+// Signal returns the signal of a message with the given payload and content topic, which is the
+// payload followed by the content topic, as defined by 17/WAKU-RLN-RELAY
+func Signal(payload []byte, contentTopic string) []byte {
+	signal := make([]byte, 0, len(payload)+len(contentTopic))
+	signal = append(signal, payload...)
+	return append(signal, contentTopic...)
+}
+
+// Marshal encodes the message as a WakuMessage protobuf message
+func (m *WakuMessage) Marshal() []byte {
+	var b []byte
+	if len(m.Payload) != 0 {
+		b = appendBytes(b, payloadField, m.Payload)
+	}
+	if m.ContentTopic != "" {
+		b = appendBytes(b, contentTopicField, []byte(m.ContentTopic))
+	}
+	if m.Version != 0 {
+		b = appendVarint(b, versionField, uint64(m.Version))
+	}
+	if m.Timestamp != 0 {
+		// sint64 values are zigzag encoded
+		b = appendVarint(b, timestampField, uint64(m.Timestamp<<1)^uint64(m.Timestamp>>63))
+	}
+	if m.RateLimitProof != nil {
+		b = appendBytes(b, rateLimitProofField, MarshalRateLimitProof(*m.RateLimitProof))
+	}
+	if m.Ephemeral {
+		b = appendVarint(b, ephemeralField, 1)
+	}
+	return b
+}
+
+// UnmarshalWakuMessage decodes a WakuMessage protobuf message. If it carries a rate limit proof,
+// the proof must be valid, see UnmarshalRateLimitProof
+func UnmarshalWakuMessage(b []byte) (*WakuMessage, error) {
+	m := &WakuMessage{}
+	// a message field that occurs more than once is the merge of its occurrences, which for
+	// RateLimitProof is the decoding of their concatenation
+	var proof []byte
+	hasProof := false
+
+	err := parse(b, func(field int, wireType int, value []byte, n uint64) error {
+		expected := wireBytes
+		switch field {
+		case versionField, timestampField, ephemeralField:
+			expected = wireVarint
+		case payloadField, contentTopicField, rateLimitProofField:
+		default:
+			return nil
+		}
+		if wireType != expected {
+			return fmt.Errorf("field %d has wire type %d", field, wireType)
+		}
+
+		switch field {
+		case payloadField:
+			m.Payload = append([]byte(nil), value...)
+		case contentTopicField:
+			m.ContentTopic = string(value)
+		case versionField:
+			m.Version = uint32(n)
+		case timestampField:
+			m.Timestamp = int64(n>>1) ^ -int64(n&1)
+		case rateLimitProofField:
+			proof = append(proof, value...)
+			hasProof = true
+		case ephemeralField:
+			m.Ephemeral = n != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if hasProof {
+		p, err := UnmarshalRateLimitProof(proof)
+		if err != nil {
+			return nil, err
+		}
+		m.RateLimitProof = &p
+	}
+
+	return m, nil
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return appendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	return appendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendBytes(b []byte, field int, value []byte) []byte {
+	b = appendUvarint(appendTag(b, field, wireBytes), uint64(len(value)))
+	return append(b, value...)
+}
+
+// parse calls `f` for each field of a message, with the content of length delimited fields or the
+// value of varint fields. Fixed size fields are only skipped
+func parse(b []byte, f func(field int, wireType int, value []byte, n uint64) error) error {
+	for len(b) > 0 {
+		tag, size := binary.Uvarint(b)
+		if size <= 0 {
+			return fmt.Errorf("%w: invalid tag", ErrMalformed)
+		}
+		b = b[size:]
+
+		field, wireType := tag>>3, int(tag&7)
+		if field == 0 || field > 1<<29-1 {
+			return fmt.Errorf("%w: invalid field number %d", ErrMalformed, field)
+		}
+
+		var value []byte
+		var n uint64
+		switch wireType {
+		case wireVarint:
+			n, size = binary.Uvarint(b)
+			if size <= 0 {
+				return fmt.Errorf("%w: invalid varint in field %d", ErrMalformed, field)
+			}
+			b = b[size:]
+		case wireBytes:
+			length, size := binary.Uvarint(b)
+			if size <= 0 || length > uint64(len(b)-size) {
+				return fmt.Errorf("%w: invalid length in field %d", ErrMalformed, field)
+			}
+			value = b[size : size+int(length)]
+			b = b[size+int(length):]
+		case wireFixed64, wireFixed32:
+			width := 8
+			if wireType == wireFixed32 {
+				width = 4
+			}
+			if len(b) < width {
+				return fmt.Errorf("%w: truncated field %d", ErrMalformed, field)
+			}
+			value = b[:width]
+			b = b[width:]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d in field %d", ErrMalformed, wireType, field)
+		}
+
+		if err := f(int(field), wireType, value, n); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package wakupb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/waku-org/go-rln/rln"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func testProof() rln.RateLimitProof {
+	var proof rln.RateLimitProof
+	for i := range proof.Proof {
+		proof.Proof[i] = byte(i)
+	}
+	for i := 0; i < 32; i++ {
+		proof.MerkleRoot[i] = 0x10 + byte(i)
+		proof.Epoch[i] = 0x30 + byte(i)
+		proof.ShareX[i] = 0x50 + byte(i)
+		proof.ShareY[i] = 0x70 + byte(i)
+		proof.Nullifier[i] = 0x90 + byte(i)
+	}
+	return proof
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// The spec does not publish encoded messages, the expected encodings are written out from the
+// protobuf wire format: a tag is the varint field<<3|wire_type, followed by a varint length for
+// length delimited fields. TestReferenceEncoder checks them against the protobuf Go module
+func proofVector(p rln.RateLimitProof) []byte {
+	return concat(
+		mustHex("0a8002"), p.Proof[:],
+		mustHex("1220"), p.MerkleRoot[:],
+		mustHex("1a20"), p.Epoch[:],
+		mustHex("2220"), p.ShareX[:],
+		mustHex("2a20"), p.ShareY[:],
+		mustHex("3220"), p.Nullifier[:],
+	)
+}
+
+func TestRateLimitProof(t *testing.T) {
+	proof := testProof()
+	expected := proofVector(proof)
+
+	encoded := MarshalRateLimitProof(proof)
+	require.Equal(t, expected, encoded)
+	require.Len(t, encoded, 429)
+
+	decoded, err := UnmarshalRateLimitProof(encoded)
+	require.NoError(t, err)
+	require.Equal(t, proof, decoded)
+
+	// the fields can come in any order, and unknown fields are skipped
+	reordered := concat(
+		mustHex("3220"), proof.Nullifier[:],
+		mustHex("4096ae3c"),
+		mustHex("2a20"), proof.ShareY[:],
+		mustHex("4d01020304"),
+		mustHex("2220"), proof.ShareX[:],
+		mustHex("510102030405060708"),
+		mustHex("1a20"), proof.Epoch[:],
+		mustHex("5a03616263"),
+		mustHex("1220"), proof.MerkleRoot[:],
+		mustHex("0a8002"), proof.Proof[:],
+	)
+	decoded, err = UnmarshalRateLimitProof(reordered)
+	require.NoError(t, err)
+	require.Equal(t, proof, decoded)
+
+	// a later occurrence of a field replaces the previous one
+	other := testProof()
+	other.Epoch[0] = 0xff
+	decoded, err = UnmarshalRateLimitProof(concat(expected, mustHex("1a20"), other.Epoch[:]))
+	require.NoError(t, err)
+	require.Equal(t, other, decoded)
+
+	// every truncation is rejected, as a field is either missing or cut
+	for i := 0; i < len(expected); i++ {
+		_, err := UnmarshalRateLimitProof(expected[:i])
+		require.True(t, errors.Is(err, ErrMalformed), "truncated at %d", i)
+	}
+
+	malformed := [][]byte{
+		// a field with the wrong wire type
+		concat(expected, mustHex("0801")),
+		// a field of the wrong size
+		concat(expected, mustHex("121f"), proof.MerkleRoot[:31]),
+		// a length beyond the end of the message
+		concat(expected, mustHex("5aff01")),
+		// a field number zero
+		concat(expected, mustHex("0200")),
+		// a group, which is not supported
+		concat(expected, mustHex("5b")),
+		// a varint that does not end
+		concat(expected, mustHex("40ffffffffffffffffffff")),
+	}
+	for i, b := range malformed {
+		_, err := UnmarshalRateLimitProof(b)
+		require.True(t, errors.Is(err, ErrMalformed), "case %d", i)
+	}
+}
+
+func TestWakuMessage(t *testing.T) {
+	proof := testProof()
+	contentTopic := "/waku/2/default-content/proto"
+	msg := &WakuMessage{
+		Payload:        []byte("Hello"),
+		ContentTopic:   contentTopic,
+		Version:        1,
+		Timestamp:      1000,
+		RateLimitProof: &proof,
+		Ephemeral:      true,
+	}
+
+	expected := concat(
+		mustHex("0a05"), []byte("Hello"),
+		mustHex("121d"), []byte(contentTopic),
+		mustHex("1801"),
+		// 1000 is zigzag encoded as 2000
+		mustHex("50d00f"),
+		// field 21 is a two byte tag, and the proof is 429 bytes long
+		mustHex("aa01ad03"), proofVector(proof),
+		mustHex("f80101"),
+	)
+
+	encoded := msg.Marshal()
+	require.Equal(t, expected, encoded)
+
+	decoded, err := UnmarshalWakuMessage(encoded)
+	require.NoError(t, err)
+	require.Equal(t, msg, decoded)
+
+	// negative timestamps are zigzag encoded as odd values
+	msg = &WakuMessage{Timestamp: -1}
+	require.Equal(t, mustHex("5001"), msg.Marshal())
+	decoded, err = UnmarshalWakuMessage(mustHex("5001"))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), decoded.Timestamp)
+
+	// the default values are omitted
+	require.Empty(t, (&WakuMessage{}).Marshal())
+	decoded, err = UnmarshalWakuMessage(nil)
+	require.NoError(t, err)
+	require.Equal(t, &WakuMessage{}, decoded)
+	require.Nil(t, decoded.RateLimitProof)
+
+	// the occurrences of the proof are merged
+	full := MarshalRateLimitProof(proof)
+	merged := concat(
+		mustHex("aa01"), appendUvarint(nil, 259), full[:259],
+		mustHex("0a05"), []byte("Hello"),
+		mustHex("aa01"), appendUvarint(nil, uint64(len(full)-259)), full[259:],
+	)
+	decoded, err = UnmarshalWakuMessage(merged)
+	require.NoError(t, err)
+	require.Equal(t, proof, *decoded.RateLimitProof)
+	require.Equal(t, []byte("Hello"), decoded.Payload)
+
+	// an invalid proof invalidates the message
+	_, err = UnmarshalWakuMessage(concat(mustHex("aa0102"), mustHex("1200")))
+	require.True(t, errors.Is(err, ErrMalformed))
+
+	// a field with the wrong wire type is rejected
+	_, err = UnmarshalWakuMessage(mustHex("0801"))
+	require.True(t, errors.Is(err, ErrMalformed))
+}
+
+func TestSignal(t *testing.T) {
+	// the signal is the payload followed by the content topic
+	require.Equal(t, []byte("Hello/waku/2/default-content/proto"), Signal([]byte("Hello"), "/waku/2/default-content/proto"))
+	require.Equal(t, []byte("/t"), Signal(nil, "/t"))
+	require.Empty(t, Signal(nil, ""))
+
+	msg := &WakuMessage{Payload: mustHex("0001"), ContentTopic: "t"}
+	require.Equal(t, mustHex("000174"), msg.Signal())
+
+	// the signal does not alias the payload
+	payload := make([]byte, 2, 10)
+	signal := Signal(payload, "t")
+	signal[0] = 1
+	require.Equal(t, byte(0), payload[0])
+}
+
+func TestProofOfMessage(t *testing.T) {
+	backend, err := rln.NewFakeBackend(rln.MERKLE_TREE_DEPTH)
+	require.NoError(t, err)
+	r, err := rln.NewRLNWithBackend(backend)
+	require.NoError(t, err)
+	defer r.Close()
+
+	key, err := r.MembershipKeyGen()
+	require.NoError(t, err)
+	index, err := r.Insert(key.IDCommitment)
+	require.NoError(t, err)
+
+	msg := &WakuMessage{Payload: []byte("Hello"), ContentTopic: "/toy-chat/2/huilong/proto"}
+	proof, err := r.GenerateProof(msg.Signal(), *key, index, rln.ToEpoch(1))
+	require.NoError(t, err)
+	msg.RateLimitProof = proof
+
+	decoded, err := UnmarshalWakuMessage(msg.Marshal())
+	require.NoError(t, err)
+
+	verified, err := r.ValidateProof(decoded.Signal(), *decoded.RateLimitProof)
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	// the proof is bound to the content topic
+	decoded.ContentTopic = "/toy-chat/2/other/proto"
+	require.False(t, r.Verify(decoded.Signal(), *decoded.RateLimitProof))
+}
+
+// wakuDescriptors builds the descriptors of the messages of 17/WAKU-RLN-RELAY listed in the
+// package documentation
+func wakuDescriptors(t *testing.T) (proofDesc, msgDesc protoreflect.MessageDescriptor) {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   typ.Enum(),
+		}
+	}
+	bytesType := descriptorpb.FieldDescriptorProto_TYPE_BYTES
+
+	proofField := field("rate_limit_proof", 21, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	proofField.TypeName = proto.String(".waku.RateLimitProof")
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("waku.proto"),
+		Package: proto.String("waku"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("RateLimitProof"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("proof", 1, bytesType),
+					field("merkle_root", 2, bytesType),
+					field("epoch", 3, bytesType),
+					field("share_x", 4, bytesType),
+					field("share_y", 5, bytesType),
+					field("nullifier", 6, bytesType),
+				},
+			},
+			{
+				Name: proto.String("WakuMessage"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("payload", 1, bytesType),
+					field("contentTopic", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					field("version", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT32),
+					field("timestamp", 10, descriptorpb.FieldDescriptorProto_TYPE_SINT64),
+					proofField,
+					field("ephemeral", 31, descriptorpb.FieldDescriptorProto_TYPE_BOOL),
+				},
+			},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	return file.Messages().ByName("RateLimitProof"), file.Messages().ByName("WakuMessage")
+}
+
+// TestReferenceEncoder checks the encoding against the one of the protobuf Go module, which
+// encodes messages built from the descriptors of the spec
+func TestReferenceEncoder(t *testing.T) {
+	proofDesc, msgDesc := wakuDescriptors(t)
+	deterministic := proto.MarshalOptions{Deterministic: true}
+
+	proof := testProof()
+	refProof := dynamicpb.NewMessage(proofDesc)
+	for i, f := range proofFields(&proof) {
+		refProof.Set(proofDesc.Fields().ByNumber(protoreflect.FieldNumber(i+1)), protoreflect.ValueOfBytes(f.value))
+	}
+
+	expected, err := deterministic.Marshal(refProof)
+	require.NoError(t, err)
+	require.Equal(t, expected, proofVector(proof))
+	require.Equal(t, expected, MarshalRateLimitProof(proof))
+
+	for _, timestamp := range []int64{1000, -1, -1 << 63} {
+		msg := &WakuMessage{
+			Payload:        []byte("Hello"),
+			ContentTopic:   "/waku/2/default-content/proto",
+			Version:        1,
+			Timestamp:      timestamp,
+			RateLimitProof: &proof,
+			Ephemeral:      true,
+		}
+
+		ref := dynamicpb.NewMessage(msgDesc)
+		fields := msgDesc.Fields()
+		ref.Set(fields.ByName("payload"), protoreflect.ValueOfBytes(msg.Payload))
+		ref.Set(fields.ByName("contentTopic"), protoreflect.ValueOfString(msg.ContentTopic))
+		ref.Set(fields.ByName("version"), protoreflect.ValueOfUint32(msg.Version))
+		ref.Set(fields.ByName("timestamp"), protoreflect.ValueOfInt64(msg.Timestamp))
+		ref.Set(fields.ByName("rate_limit_proof"), protoreflect.ValueOfMessage(refProof))
+		ref.Set(fields.ByName("ephemeral"), protoreflect.ValueOfBool(msg.Ephemeral))
+
+		expected, err := deterministic.Marshal(ref)
+		require.NoError(t, err)
+		require.Equal(t, expected, msg.Marshal(), "timestamp %d", timestamp)
+
+		// the reference decodes the encoding to the same message
+		decoded := dynamicpb.NewMessage(msgDesc)
+		require.NoError(t, proto.Unmarshal(msg.Marshal(), decoded))
+		require.True(t, proto.Equal(ref, decoded))
+	}
+
+	// the default values are omitted by both encoders
+	empty, err := deterministic.Marshal(dynamicpb.NewMessage(msgDesc))
+	require.NoError(t, err)
+	require.Empty(t, empty)
+	require.Empty(t, (&WakuMessage{}).Marshal())
+}